@@ -1,8 +1,14 @@
 package bucket
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
 )
 
 // GCRALimiter implements the Generic Cell Rate Algorithm.
@@ -17,26 +23,22 @@ type GCRALimiter struct {
 	tat      time.Time     // Theoretical Arrival Time
 	emission time.Duration // Time between requests (1/rate)
 	limit    time.Duration // Burst tolerance (emission * burst)
-	clock    clock
+	clock    clock.Clock
 }
 
 // NewGCRALimiter creates a new GCRA limiter.
 // rate is requests per second, burst is how many requests can be made instantly.
 func NewGCRALimiter(rate float64, burst uint32) *GCRALimiter {
-	return NewGCRALimiterWithClock(rate, burst, realClock{})
+	return NewGCRALimiterWithClock(rate, burst, clock.Real{})
 }
 
 // NewGCRALimiterWithClock creates a new GCRA limiter with a custom clock.
-func NewGCRALimiterWithClock(rate float64, burst uint32, clock clock) *GCRALimiter {
-	if rate <= 0 {
-		rate = 1
-	}
-
+func NewGCRALimiterWithClock(rate float64, burst uint32, clock clock.Clock) *GCRALimiter {
 	if burst == 0 {
 		burst = 1
 	}
 
-	emission := time.Duration(float64(time.Second) / rate)
+	emission := emissionOf(rate)
 	limit := emission * time.Duration(burst)
 
 	return &GCRALimiter{
@@ -47,30 +49,207 @@ func NewGCRALimiterWithClock(rate float64, burst uint32, clock clock) *GCRALimit
 	}
 }
 
+// emissionOf returns the emission interval of rate. Rates above one request
+// per nanosecond are capped at it, so the interval is never zero.
+func emissionOf(rate float64) time.Duration {
+	if rate <= 0 {
+		rate = 1
+	}
+
+	return max(1, time.Duration(float64(time.Second)/rate))
+}
+
+// SetRate changes the sustained rate in requests per second, keeping the burst.
+// Requests already admitted ahead of time stay admitted: the TAT is re-based
+// so the same number of requests is outstanding at the new emission interval.
+func (l *GCRALimiter) SetRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := l.limit / l.emission
+	emission := emissionOf(rate)
+
+	now := l.clock.Now()
+	if ahead := l.tat.Sub(now); ahead > 0 {
+		outstanding := float64(ahead) / float64(l.emission)
+		l.tat = now.Add(time.Duration(outstanding * float64(emission)))
+	}
+
+	l.emission = emission
+	l.limit = emission * burst
+}
+
+// SetBurst changes how many requests can be made instantly, keeping the rate.
+// The burst tolerance is recomputed while the TAT is left as is, so credit
+// already used counts against the new burst.
+func (l *GCRALimiter) SetBurst(burst uint32) {
+	if burst == 0 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = l.emission * time.Duration(burst)
+}
+
 // Allow reports whether a request is allowed.
 // Returns true if the request fits within the rate limit, false otherwise.
 func (l *GCRALimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests may be made at once. On success the TAT
+// advances by n emission intervals; on failure it is left untouched.
+func (l *GCRALimiter) AllowN(n uint32) bool {
+	return l.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the limiter state.
+func (l *GCRALimiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the limiter state. Limit is the
+// burst, Remaining is the burst credit left and ResetAt is the TAT, after
+// which the full burst is available again.
+func (l *GCRALimiter) DecideN(n uint32) rate.Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay, err := l.take(n)
+	now := l.clock.Now()
+	tat := maxTime(now, l.tat)
+
+	return rate.Decision{
+		Allowed:    err == nil && delay == 0,
+		Limit:      uint32(l.limit / l.emission),
+		Remaining:  uint32(max(0, now.Add(l.limit).Sub(tat)) / l.emission),
+		ResetAt:    tat,
+		RetryAfter: retryAfter(delay, err),
+	}
+}
+
+// RecoveredAt returns the TAT, after which the full burst is available again.
+// A GCRA limiter always recovers, so ok is always true.
+func (l *GCRALimiter) RecoveredAt() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.tat, true
+}
+
+// MarshalJSON encodes the TAT.
+func (l *GCRALimiter) MarshalJSON() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return json.Marshal(gcraState{TAT: l.tat})
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the rate and
+// burst.
+func (l *GCRALimiter) UnmarshalJSON(data []byte) error {
+	var s gcraState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tat = s.TAT
+
+	return nil
+}
+
+// Wait blocks until a request conforms to the rate or ctx is done.
+func (l *GCRALimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests conform to the rate and then admits them.
+// It returns ErrExceedsBurst if n is larger than the burst,
+// ErrWouldExceedDeadline if the TAT does not allow them before the ctx
+// deadline, or the ctx error if ctx is done while waiting.
+func (l *GCRALimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, l.clock, func() (time.Duration, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (l *GCRALimiter) Reserve() *Reservation {
+	return l.ReserveN(1)
+}
+
+// ReserveN advances the TAT by n emissions now and returns a Reservation whose
+// Delay is the time until the requests conform. Later requests are scheduled
+// after the reservation. The reservation is not OK, and the TAT is left
+// untouched, if n exceeds the burst.
+func (l *GCRALimiter) ReserveN(n uint32) *Reservation {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	now := l.clock.Now()
+	r := &Reservation{n: n, clock: l.clock, refund: l.RefundN, timeToAct: now}
 
-	// Calculate new TAT: max(now, old_tat) + emission
-	newTAT := l.tat
-	if now.After(newTAT) {
-		newTAT = now
+	if time.Duration(n) > l.limit/l.emission {
+		return r
 	}
 
-	newTAT = newTAT.Add(l.emission)
+	l.tat = maxTime(now, l.tat).Add(l.emission * time.Duration(n))
+	r.timeToAct = maxTime(now, l.tat.Add(-l.limit))
+	r.ok = true
+
+	return r
+}
+
+// RefundN moves the TAT back by the n emissions admitted by AllowN, DecideN or
+// WaitN, e.g. when the request was denied by another limiter after all. Credit
+// that would go back further than now is lost, as the TAT never counts from
+// before now.
+func (l *GCRALimiter) RefundN(n uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tat = l.tat.Add(-l.emission * time.Duration(n))
+}
+
+// take advances the TAT by n emissions if the requests conform and returns a
+// zero delay. Otherwise it returns how long until they would conform.
+// The caller must hold l.mu.
+func (l *GCRALimiter) take(n uint32) (time.Duration, error) {
+	// More than the burst never conforms; checked first so that
+	// n * emission cannot overflow.
+	if time.Duration(n) > l.limit/l.emission {
+		return 0, ErrExceedsBurst
+	}
+
+	now := l.clock.Now()
+
+	// Calculate new TAT: max(now, old_tat) + n * emission
+	newTAT := maxTime(now, l.tat).Add(l.emission * time.Duration(n))
 
 	// Allow if newTAT - limit <= now
 	// This means we haven't exhausted our burst credit
 	allowAt := newTAT.Add(-l.limit)
 	if allowAt.After(now) {
-		return false
+		return allowAt.Sub(now), nil
 	}
 
 	l.tat = newTAT
 
-	return true
+	return 0, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
 }
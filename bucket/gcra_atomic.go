@@ -0,0 +1,170 @@
+package bucket
+
+import (
+	"encoding/json"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
+)
+
+// AtomicGCRALimiter is a lock-free GCRALimiter. It keeps the Theoretical
+// Arrival Time as int64 nanoseconds since a monotonic epoch taken at
+// construction, and advances it with compare-and-swap instead of a mutex.
+// Decisions are identical to GCRALimiter's.
+//
+// Contended callers retry a cheap CAS instead of parking on a mutex, and the
+// TAT arithmetic is done on integers rather than time.Time values.
+// BenchmarkGCRALimiter_Allow_Parallel compares both implementations.
+// Unlike GCRALimiter, the rate and burst are fixed at construction.
+type AtomicGCRALimiter struct {
+	tat      atomic.Int64 // Theoretical Arrival Time, ns since epoch
+	epoch    time.Time
+	emission int64 // Time between requests (1/rate), ns
+	limit    int64 // Burst tolerance (emission * burst), ns
+	clock    clock.Clock
+}
+
+// NewAtomicGCRALimiter creates a new lock-free GCRA limiter.
+// rate is requests per second, burst is how many requests can be made instantly.
+func NewAtomicGCRALimiter(rate float64, burst uint32) *AtomicGCRALimiter {
+	return NewAtomicGCRALimiterWithClock(rate, burst, clock.Real{})
+}
+
+// NewAtomicGCRALimiterWithClock creates a new lock-free GCRA limiter with a
+// custom clock.
+func NewAtomicGCRALimiterWithClock(rate float64, burst uint32, clock clock.Clock) *AtomicGCRALimiter {
+	if burst == 0 {
+		burst = 1
+	}
+
+	emission := emissionOf(rate)
+
+	l := &AtomicGCRALimiter{
+		epoch:    clock.Now(),
+		emission: int64(emission),
+		limit:    int64(emission) * int64(burst),
+		clock:    clock,
+	}
+	l.tat.Store(math.MinInt64) // Far in the past - allows first burst
+
+	return l
+}
+
+// Allow reports whether a request is allowed.
+// Returns true if the request fits within the rate limit, false otherwise.
+func (l *AtomicGCRALimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests may be made at once. On success the TAT
+// advances by n emission intervals; on failure it is left untouched.
+func (l *AtomicGCRALimiter) AllowN(n uint32) bool {
+	allowed, _, _ := l.take(n)
+
+	return allowed
+}
+
+// Decide is like Allow but also reports the limiter state.
+func (l *AtomicGCRALimiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the limiter state. Limit is the
+// burst, Remaining is the burst credit left and ResetAt is the TAT, after
+// which the full burst is available again.
+func (l *AtomicGCRALimiter) DecideN(n uint32) rate.Decision {
+	allowed, now, tat := l.take(n)
+	tat = max(now, tat)
+
+	d := rate.Decision{
+		Allowed:   allowed,
+		Limit:     uint32(l.limit / l.emission),
+		Remaining: uint32(max(0, now+l.limit-tat) / l.emission),
+		ResetAt:   l.epoch.Add(time.Duration(tat)),
+	}
+
+	if !allowed && int64(n) <= l.limit/l.emission {
+		d.RetryAfter = time.Duration(tat + int64(n)*l.emission - l.limit - now)
+	}
+
+	return d
+}
+
+// RecoveredAt returns the TAT, after which the full burst is available again.
+// A GCRA limiter always recovers, so ok is always true.
+func (l *AtomicGCRALimiter) RecoveredAt() (time.Time, bool) {
+	return l.epoch.Add(time.Duration(max(0, l.tat.Load()))), true
+}
+
+// MarshalJSON encodes the TAT as a wall clock time, in the same format as
+// GCRALimiter, so state can move between the two implementations.
+func (l *AtomicGCRALimiter) MarshalJSON() ([]byte, error) {
+	var s gcraState
+	if tat := l.tat.Load(); tat != math.MinInt64 {
+		s.TAT = l.epoch.Add(time.Duration(tat))
+	}
+
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, re-basing the TAT on
+// this limiter's epoch and keeping the rate and burst.
+func (l *AtomicGCRALimiter) UnmarshalJSON(data []byte) error {
+	var s gcraState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	tat := int64(math.MinInt64)
+	if !s.TAT.IsZero() {
+		tat = int64(s.TAT.Sub(l.epoch))
+	}
+
+	l.tat.Store(tat)
+
+	return nil
+}
+
+// RefundN moves the TAT back by the n emissions admitted by AllowN or DecideN,
+// e.g. when the request was denied by another limiter after all.
+func (l *AtomicGCRALimiter) RefundN(n uint32) {
+	for {
+		tat := l.tat.Load()
+		if tat == math.MinInt64 {
+			return
+		}
+
+		if l.tat.CompareAndSwap(tat, tat-int64(n)*l.emission) {
+			return
+		}
+	}
+}
+
+// take tries to advance the TAT by n emissions. It returns whether it did,
+// the time it decided at and the TAT after the decision, both in ns since
+// the epoch.
+func (l *AtomicGCRALimiter) take(n uint32) (bool, int64, int64) {
+	for {
+		now := int64(l.clock.Now().Sub(l.epoch))
+		tat := l.tat.Load()
+
+		// More than the burst never conforms; checked first so that
+		// n * emission cannot overflow.
+		if int64(n) > l.limit/l.emission {
+			return false, now, tat
+		}
+
+		newTAT := max(now, tat) + int64(n)*l.emission
+		if newTAT-l.limit > now {
+			return false, now, tat
+		}
+
+		if l.tat.CompareAndSwap(tat, newTAT) {
+			return true, now, newTAT
+		}
+	}
+}
@@ -132,3 +132,302 @@ func TestNewGCRALimiter_DefaultValues(t *testing.T) {
 	require.NotNil(t, lim)
 	require.True(t, lim.Allow())
 }
+
+func TestGCRALimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	lim := bucket.NewGCRALimiterWithClock(10, 5, clock)
+
+	require.True(t, lim.AllowN(3))
+	require.True(t, lim.AllowN(0))
+
+	// Only 2 left in the burst - a request for 3 is rejected and leaves TAT alone
+	require.False(t, lim.AllowN(3))
+	require.True(t, lim.AllowN(2))
+	require.False(t, lim.AllowN(1))
+
+	// Advance 300ms = 3 emissions
+	clock.advance(300 * time.Millisecond)
+	require.False(t, lim.AllowN(4))
+	require.True(t, lim.AllowN(3))
+
+	// More than the burst can never be allowed
+	clock.advance(time.Hour)
+	require.False(t, lim.AllowN(6))
+	require.True(t, lim.AllowN(5))
+}
+
+func TestGCRALimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	lim := bucket.NewGCRALimiterWithClock(10, 5, clock)
+
+	d := lim.DecideN(3)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(5), d.Limit)
+	require.Equal(t, uint32(2), d.Remaining)
+	require.Equal(t, clock.now.Add(300*time.Millisecond), d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+
+	// One too many - must wait one emission
+	d = lim.DecideN(3)
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(2), d.Remaining)
+	require.Equal(t, 100*time.Millisecond, d.RetryAfter)
+
+	require.Zero(t, lim.DecideN(6).RetryAfter)
+}
+
+func TestGCRALimiter_SetRate(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 4
+	lim := bucket.NewGCRALimiterWithClock(10, 4, clock)
+	require.True(t, lim.AllowN(3))
+
+	// The three outstanding requests are re-based to 1s each
+	lim.SetRate(1)
+
+	d := lim.DecideN(0)
+	require.Equal(t, uint32(4), d.Limit)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, clock.now.Add(3*time.Second), d.ResetAt)
+
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	clock.advance(time.Second)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	// Invalid rate falls back to the constructor default
+	lim.SetRate(0)
+	require.Equal(t, uint32(4), lim.DecideN(0).Limit)
+}
+
+func TestGCRALimiter_AboveOnePerNanosecond(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewGCRALimiterWithClock(2e9, 10, clock)
+
+	// Capped at one request per nanosecond
+	require.True(t, lim.AllowN(10))
+	require.False(t, lim.Allow())
+	require.Equal(t, uint32(10), lim.DecideN(0).Limit)
+
+	clock.advance(time.Nanosecond)
+	require.True(t, lim.Allow())
+
+	lim.SetRate(3e9)
+	clock.advance(time.Nanosecond)
+	require.True(t, lim.Allow())
+
+	require.True(t, bucket.NewGCRALimiter(2e9, 10).Allow())
+}
+
+func TestAtomicGCRALimiter_AboveOnePerNanosecond(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewAtomicGCRALimiterWithClock(2e9, 10, clock)
+
+	// Capped at one request per nanosecond, as GCRALimiter
+	require.True(t, lim.AllowN(10))
+	require.False(t, lim.Allow())
+	require.Equal(t, uint32(10), lim.DecideN(0).Limit)
+
+	clock.advance(time.Nanosecond)
+	require.True(t, lim.Allow())
+
+	require.True(t, bucket.NewAtomicGCRALimiter(2e9, 10).Allow())
+}
+
+func TestGCRALimiter_SetBurst(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	lim := bucket.NewGCRALimiterWithClock(10, 5, clock)
+	require.True(t, lim.AllowN(3))
+
+	// Credit already used counts against the new burst
+	lim.SetBurst(4)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	lim.SetBurst(0)
+	clock.advance(time.Hour)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	lim.SetBurst(8)
+	require.True(t, lim.AllowN(7))
+}
+
+func TestAtomicGCRALimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 3
+	lim := bucket.NewAtomicGCRALimiterWithClock(10, 3, clock)
+
+	require.True(t, lim.Allow())
+	require.True(t, lim.AllowN(2))
+	require.False(t, lim.Allow())
+
+	// Advance 100ms = 1 request worth
+	clock.advance(100 * time.Millisecond)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	// Idle credit is capped at the burst
+	clock.advance(time.Hour)
+	require.False(t, lim.AllowN(4))
+	require.True(t, lim.AllowN(3))
+
+	require.True(t, bucket.NewAtomicGCRALimiter(0, 0).Allow())
+}
+
+// TestAtomicGCRALimiter_MatchesGCRALimiter replays the same arrivals against
+// both implementations and expects identical decisions.
+func TestAtomicGCRALimiter_MatchesGCRALimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	mutex := bucket.NewGCRALimiterWithClock(7, 4, clock)
+	lockFree := bucket.NewAtomicGCRALimiterWithClock(7, 4, clock)
+
+	steps := []time.Duration{0, 0, 10, 50, 0, 140, 143, 0, 0, 300, 1, 1, 2000, 0, 0, 0, 0, 0}
+	for i, step := range steps {
+		clock.advance(step * time.Millisecond)
+
+		n := uint32(i%3 + 1)
+		require.Equal(t, mutex.DecideN(n), lockFree.DecideN(n), "step %d", i)
+	}
+}
+
+func TestAtomicGCRALimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	lim := bucket.NewAtomicGCRALimiterWithClock(10, 5, clock)
+
+	d := lim.DecideN(3)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(5), d.Limit)
+	require.Equal(t, uint32(2), d.Remaining)
+	require.True(t, clock.now.Add(300*time.Millisecond).Equal(d.ResetAt))
+
+	d = lim.DecideN(3)
+	require.False(t, d.Allowed)
+	require.Equal(t, 100*time.Millisecond, d.RetryAfter)
+
+	require.Zero(t, lim.DecideN(6).RetryAfter)
+}
+
+func TestAtomicGCRALimiter_Allow_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	// Very low rate (no refill during test), burst of 100
+	lim := bucket.NewAtomicGCRALimiter(0.001, 100)
+
+	var (
+		allowed atomic.Int64
+		wg      sync.WaitGroup
+	)
+
+	for range 200 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 10 {
+				if lim.Allow() {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.Equal(t, int64(100), allowed.Load())
+}
+
+func BenchmarkGCRALimiter_Allow_Parallel(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		lim := bucket.NewGCRALimiter(1e9, 1000)
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lim.Allow()
+			}
+		})
+	})
+
+	b.Run("atomic", func(b *testing.B) {
+		lim := bucket.NewAtomicGCRALimiter(1e9, 1000)
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lim.Allow()
+			}
+		})
+	})
+}
+
+func TestGCRALimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	mutex := bucket.NewGCRALimiterWithClock(10, 5, clock)
+	lockFree := bucket.NewAtomicGCRALimiterWithClock(10, 5, clock)
+
+	for _, lim := range []interface {
+		AllowN(n uint32) bool
+		RecoveredAt() (time.Time, bool)
+	}{mutex, lockFree} {
+		at, ok := lim.RecoveredAt()
+		require.True(t, ok)
+		require.False(t, at.After(clock.now))
+
+		require.True(t, lim.AllowN(3))
+
+		at, ok = lim.RecoveredAt()
+		require.True(t, ok)
+		require.True(t, clock.now.Add(300*time.Millisecond).Equal(at))
+	}
+}
+
+func TestGCRALimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+
+	for _, lim := range []interface {
+		Allow() bool
+		AllowN(n uint32) bool
+		RefundN(n uint32)
+	}{
+		bucket.NewGCRALimiterWithClock(1, 4, clock),
+		bucket.NewAtomicGCRALimiterWithClock(1, 4, clock),
+	} {
+		// Refunding a fresh limiter gives no extra credit
+		lim.RefundN(2)
+		require.True(t, lim.AllowN(4))
+		require.False(t, lim.Allow())
+
+		lim.RefundN(3)
+		require.True(t, lim.AllowN(3))
+		require.False(t, lim.Allow())
+	}
+}
@@ -1,8 +1,14 @@
 package bucket
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
 )
 
 // LeakyLimiter implements a leaky bucket rate limiter. Requests fill the bucket,
@@ -13,18 +19,18 @@ type LeakyLimiter struct {
 
 	capacity, level, rate float64
 	lastUpdatedAt         time.Time
-	clock                 clock
+	clock                 clock.Clock
 }
 
 // NewLeakyLimiter creates a new leaky bucket limiter.
 // Capacity is the maximum bucket size. Rate is how many requests drain per second.
 func NewLeakyLimiter(capacity, rate uint32) *LeakyLimiter {
-	return NewLeakyLimiterWithClock(capacity, rate, realClock{})
+	return NewLeakyLimiterWithClock(capacity, rate, clock.Real{})
 }
 
 // NewLeakyLimiterWithClock creates a new leaky bucket limiter with a custom clock.
 // Use this constructor for testing with a mock clock.
-func NewLeakyLimiterWithClock(capacity, rate uint32, clock clock) *LeakyLimiter {
+func NewLeakyLimiterWithClock(capacity, rate uint32, clock clock.Clock) *LeakyLimiter {
 	return &LeakyLimiter{
 		capacity:      float64(capacity),
 		rate:          float64(rate),
@@ -33,6 +39,26 @@ func NewLeakyLimiterWithClock(capacity, rate uint32, clock clock) *LeakyLimiter
 	}
 }
 
+// SetCapacity changes the bucket size. The current level is kept, so a bucket
+// holding more than the new capacity rejects requests until it drains below it.
+func (lim *LeakyLimiter) SetCapacity(capacity uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.update()
+	lim.capacity = float64(capacity)
+}
+
+// SetRate changes how many requests drain per second. The bucket drains at the
+// old rate up to the change.
+func (lim *LeakyLimiter) SetRate(rate uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.update()
+	lim.rate = float64(rate)
+}
+
 func (lim *LeakyLimiter) update() {
 	t := lim.clock.Now()
 	if t.Before(lim.lastUpdatedAt) {
@@ -47,16 +73,168 @@ func (lim *LeakyLimiter) update() {
 // if there is room and returns true. If the bucket is full, it returns false
 // without blocking.
 func (lim *LeakyLimiter) Allow() bool {
+	return lim.AllowN(1)
+}
+
+// AllowN reports whether n requests fit into the bucket at once. It adds n to
+// the bucket level if there is room for all of them and returns true,
+// otherwise it leaves the level untouched and returns false.
+func (lim *LeakyLimiter) AllowN(n uint32) bool {
+	return lim.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the bucket state.
+func (lim *LeakyLimiter) Decide() rate.Decision {
+	return lim.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the bucket state. Remaining is the
+// whole room left in the bucket and ResetAt is when it will have drained.
+func (lim *LeakyLimiter) DecideN(n uint32) rate.Decision {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	delay, err := lim.take(n)
+	d := rate.Decision{
+		Allowed:    err == nil && delay == 0,
+		Limit:      uint32(lim.capacity),
+		Remaining:  uint32(max(0, lim.capacity-lim.level)),
+		RetryAfter: retryAfter(delay, err),
+	}
+
+	switch {
+	case lim.level <= 0:
+		d.ResetAt = lim.lastUpdatedAt
+	case lim.rate > 0:
+		d.ResetAt = lim.lastUpdatedAt.Add(clock.Seconds(lim.level / lim.rate))
+	}
+
+	return d
+}
+
+// RecoveredAt returns when the bucket will have drained. ok is false if it is
+// not empty and never leaks.
+func (lim *LeakyLimiter) RecoveredAt() (time.Time, bool) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	switch {
+	case lim.level <= 0:
+		return lim.lastUpdatedAt, true
+	case lim.rate > 0:
+		return lim.lastUpdatedAt.Add(clock.Seconds(lim.level / lim.rate)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// MarshalJSON encodes the bucket's level and last update time.
+func (lim *LeakyLimiter) MarshalJSON() ([]byte, error) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	return json.Marshal(leakyState{Level: lim.level, LastUpdatedAt: lim.lastUpdatedAt})
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the bucket's
+// capacity and rate. As with SetCapacity, a level above the capacity is kept
+// and drains before new requests fit.
+func (lim *LeakyLimiter) UnmarshalJSON(data []byte) error {
+	var s leakyState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.level = max(0, s.Level)
+	lim.lastUpdatedAt = state.NotAfter(s.LastUpdatedAt, lim.clock.Now())
+
+	return nil
+}
+
+// Wait blocks until there is room for a request in the bucket or ctx is done.
+func (lim *LeakyLimiter) Wait(ctx context.Context) error {
+	return lim.WaitN(ctx, 1)
+}
+
+// WaitN blocks until there is room for n requests and adds them to the bucket.
+// It returns ErrExceedsBurst if n is larger than capacity,
+// ErrWouldExceedDeadline if the bucket cannot drain enough before the ctx
+// deadline, or the ctx error if ctx is done while waiting.
+func (lim *LeakyLimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, lim.clock, func() (time.Duration, error) {
+		lim.mu.Lock()
+		defer lim.mu.Unlock()
+
+		return lim.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (lim *LeakyLimiter) Reserve() *Reservation {
+	return lim.ReserveN(1)
+}
+
+// ReserveN adds n to the bucket now, even if that overfills it, and returns a
+// Reservation whose Delay is the time until the excess has drained. This
+// gives the leaky bucket its queuing semantics: each reservation is scheduled
+// after the ones before it. The reservation is not OK, and nothing is added,
+// if n exceeds capacity or the bucket never drains.
+func (lim *LeakyLimiter) ReserveN(n uint32) *Reservation {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
 	lim.update()
 
-	if lim.level+1 <= lim.capacity {
-		lim.level++
+	r := &Reservation{n: n, clock: lim.clock, refund: lim.RefundN, timeToAct: lim.lastUpdatedAt}
+
+	level := lim.level + float64(n)
+	if float64(n) > lim.capacity || (level > lim.capacity && lim.rate == 0) {
+		return r
+	}
+
+	lim.level = level
+	r.ok = true
+
+	if level > lim.capacity {
+		r.timeToAct = r.timeToAct.Add(clock.Seconds((level - lim.capacity) / lim.rate))
+	}
+
+	return r
+}
+
+// RefundN takes n requests added by AllowN, DecideN or WaitN back out of the
+// bucket, e.g. when the request was denied by another limiter after all. The
+// level never drops below empty.
+func (lim *LeakyLimiter) RefundN(n uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.update()
+	lim.level = max(0, lim.level-float64(n))
+}
+
+// take adds n to the level if there is room and returns a zero delay.
+// Otherwise it leaves the level untouched and returns the time until enough
+// has drained. The caller must hold lim.mu.
+func (lim *LeakyLimiter) take(n uint32) (time.Duration, error) {
+	lim.update()
+
+	if lim.level+float64(n) <= lim.capacity {
+		lim.level += float64(n)
+
+		return 0, nil
+	}
+
+	if float64(n) > lim.capacity {
+		return 0, ErrExceedsBurst
+	}
 
-		return true
+	if lim.rate == 0 {
+		return clock.Forever, nil
 	}
 
-	return false
+	return clock.Seconds((lim.level + float64(n) - lim.capacity) / lim.rate), nil
 }
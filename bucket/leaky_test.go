@@ -126,3 +126,132 @@ func TestLeakyLimiter_Allow_PartialDrain(t *testing.T) {
 	require.True(t, lim.Allow())
 	require.False(t, lim.Allow())
 }
+
+func TestLeakyLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(10, 2, clock)
+
+	require.True(t, lim.AllowN(7))
+	require.True(t, lim.AllowN(0))
+
+	// Only room for 3 more - a request for 4 is rejected and adds nothing
+	require.False(t, lim.AllowN(4))
+	require.True(t, lim.AllowN(3))
+	require.False(t, lim.AllowN(1))
+
+	// Advance 1 second - drains 2
+	clock.advance(time.Second)
+	require.False(t, lim.AllowN(3))
+	require.True(t, lim.AllowN(2))
+
+	// More than capacity can never be allowed
+	clock.advance(time.Hour)
+	require.False(t, lim.AllowN(11))
+	require.True(t, lim.AllowN(10))
+}
+
+func TestLeakyLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(4, 2, clock) // drains 2 per second
+
+	d := lim.DecideN(3)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(4), d.Limit)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, clock.now.Add(1500*time.Millisecond), d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+
+	// One too many - must drain for half a second
+	d = lim.DecideN(2)
+	require.False(t, d.Allowed)
+	require.Equal(t, 500*time.Millisecond, d.RetryAfter)
+
+	// Empty bucket resets now
+	clock.advance(time.Hour)
+	require.Equal(t, clock.now, lim.DecideN(0).ResetAt)
+
+	// Never drains
+	lim = bucket.NewLeakyLimiter(1, 0)
+	require.True(t, lim.Decide().Allowed)
+
+	d = lim.Decide()
+	require.False(t, d.Allowed)
+	require.Zero(t, d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+}
+
+func TestLeakyLimiter_SetCapacity(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(6))
+
+	// Level 6 is above the new capacity - rejected until it drains below
+	lim.SetCapacity(4)
+	require.False(t, lim.Allow())
+
+	clock.advance(3 * time.Second)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+}
+
+func TestLeakyLimiter_SetRate(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(10))
+
+	// 2 seconds at the old rate, then 2 seconds at the new rate
+	clock.advance(2 * time.Second)
+	lim.SetRate(3)
+	clock.advance(2 * time.Second)
+
+	require.False(t, lim.AllowN(9))
+	require.True(t, lim.AllowN(8))
+}
+
+func TestLeakyLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(4, 2, clock)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now, at)
+
+	require.True(t, lim.AllowN(3))
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now.Add(1500*time.Millisecond), at)
+
+	// A bucket that never leaks never drains
+	lim = bucket.NewLeakyLimiterWithClock(1, 0, clock)
+	require.True(t, lim.Allow())
+
+	_, ok = lim.RecoveredAt()
+	require.False(t, ok)
+}
+
+func TestLeakyLimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(4, 0, clock)
+
+	require.True(t, lim.AllowN(3))
+	lim.RefundN(2)
+	require.Equal(t, uint32(3), lim.DecideN(0).Remaining)
+
+	// The level never drops below empty
+	lim.RefundN(5)
+	require.Equal(t, uint32(4), lim.DecideN(0).Remaining)
+	require.True(t, lim.AllowN(4))
+}
@@ -0,0 +1,54 @@
+package bucket
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// Reservation holds permits taken in advance by ReserveN. The caller should
+// wait for Delay before acting, or call Cancel if the work is abandoned so
+// the permits are returned to the limiter.
+type Reservation struct {
+	ok        bool
+	n         uint32
+	timeToAct time.Time
+	clock     clock.Clock
+	refund    func(n uint32)
+	cancelled atomic.Bool
+}
+
+// OK reports whether the limiter could grant the reservation. A reservation
+// is not OK when n exceeds the burst or the limiter never refills. Such a
+// reservation holds no permits and its Delay is effectively infinite.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the caller must wait before acting on the reservation.
+// Zero means it can act immediately.
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.clock.Now())
+}
+
+// DelayFrom returns how long after t the caller must wait before acting on
+// the reservation.
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return clock.Forever
+	}
+
+	return max(0, r.timeToAct.Sub(t))
+}
+
+// Cancel returns the reserved permits to the limiter. Call it only when the
+// reserved work will not happen; calling it more than once, or on a
+// reservation that is not OK, has no effect.
+func (r *Reservation) Cancel() {
+	if !r.ok || !r.cancelled.CompareAndSwap(false, true) {
+		return
+	}
+
+	r.refund(r.n)
+}
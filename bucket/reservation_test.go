@@ -0,0 +1,130 @@
+package bucket_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Reserve(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(2, 2, clock)
+
+	// Tokens available - act immediately
+	r := lim.ReserveN(2)
+	require.True(t, r.OK())
+	require.Zero(t, r.Delay())
+
+	// Deficit of one token at 2 tokens/second
+	r = lim.Reserve()
+	require.True(t, r.OK())
+	require.Equal(t, 500*time.Millisecond, r.Delay())
+	require.Equal(t, 300*time.Millisecond, r.DelayFrom(clock.now.Add(200*time.Millisecond)))
+
+	// Later reservations queue behind it
+	require.Equal(t, time.Second, lim.Reserve().Delay())
+	require.False(t, lim.Allow())
+
+	clock.advance(time.Second)
+	require.Zero(t, r.Delay())
+	require.False(t, lim.Allow())
+}
+
+func TestLimiter_Reserve_Cancel(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(4, 1, clock)
+
+	require.True(t, lim.AllowN(3))
+
+	r := lim.ReserveN(3)
+	require.Equal(t, 2*time.Second, r.Delay())
+
+	// Cancelling returns the permits, only once
+	r.Cancel()
+	r.Cancel()
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	// Refunds never overfill the bucket
+	clock.advance(time.Hour)
+
+	r = lim.ReserveN(2)
+	require.True(t, lim.AllowN(2))
+	r.Cancel()
+	clock.advance(time.Hour)
+	require.False(t, lim.AllowN(5))
+	require.True(t, lim.AllowN(4))
+}
+
+func TestLimiter_Reserve_NotOK(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(2, 0)
+
+	r := lim.ReserveN(3)
+	require.False(t, r.OK())
+	r.Cancel()
+
+	require.True(t, lim.AllowN(2))
+
+	// Never refills, so a deficit can't be reserved
+	r = lim.Reserve()
+	require.False(t, r.OK())
+	require.Greater(t, r.Delay(), 24*time.Hour)
+}
+
+func TestLeakyLimiter_Reserve(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(2, 2, clock) // drains 2 per second
+
+	require.Zero(t, lim.ReserveN(2).Delay())
+
+	// Queued behind the full bucket
+	r := lim.Reserve()
+	require.True(t, r.OK())
+	require.Equal(t, 500*time.Millisecond, r.Delay())
+	require.Equal(t, time.Second, lim.Reserve().Delay())
+
+	// Cancelling drains the reservation from the bucket
+	r.Cancel()
+	clock.advance(time.Second)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	require.False(t, lim.ReserveN(3).OK())
+	require.False(t, bucket.NewLeakyLimiter(1, 0).ReserveN(2).OK())
+	require.True(t, bucket.NewLeakyLimiter(1, 0).Reserve().OK())
+}
+
+func TestGCRALimiter_Reserve(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 3
+	lim := bucket.NewGCRALimiterWithClock(10, 3, clock)
+
+	require.Zero(t, lim.ReserveN(3).Delay())
+
+	r := lim.ReserveN(2)
+	require.True(t, r.OK())
+	require.Equal(t, 200*time.Millisecond, r.Delay())
+	require.Equal(t, 300*time.Millisecond, lim.Reserve().Delay())
+	require.False(t, lim.Allow())
+
+	// Cancelling moves the TAT back by two emissions, so only the single
+	// reservation is still queued ahead
+	r.Cancel()
+	clock.advance(200 * time.Millisecond)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	require.False(t, lim.ReserveN(4).OK())
+}
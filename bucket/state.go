@@ -0,0 +1,20 @@
+package bucket
+
+import "time"
+
+// The states the limiters marshal to JSON; see the internal/state package.
+// Buckets refill and TATs pass over time spent in between.
+
+type tokenState struct {
+	Tokens       float64   `json:"tokens"`
+	LastRefillAt time.Time `json:"lastRefillAt"`
+}
+
+type leakyState struct {
+	Level         float64   `json:"level"`
+	LastUpdatedAt time.Time `json:"lastUpdatedAt"`
+}
+
+type gcraState struct {
+	TAT time.Time `json:"tat"`
+}
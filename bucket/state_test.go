@@ -0,0 +1,131 @@
+package bucket_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(6))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// Time passed while down still refills
+	clock.advance(2 * time.Second)
+
+	restored := bucket.NewLimiterWithClock(10, 1, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(7))
+	require.True(t, restored.AllowN(6))
+
+	// The new capacity applies
+	restored = bucket.NewLimiterWithClock(2, 0, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(3))
+	require.True(t, restored.AllowN(2))
+}
+
+func TestLimiter_UnmarshalJSON_FutureRefill(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(10))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// Restored on a host whose clock is behind
+	clock.advance(-time.Minute)
+
+	restored := bucket.NewLimiterWithClock(10, 1, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.Allow())
+
+	clock.advance(time.Second)
+	require.True(t, restored.Allow())
+}
+
+func TestLeakyLimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLeakyLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(8))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	clock.advance(2 * time.Second)
+
+	restored := bucket.NewLeakyLimiterWithClock(10, 1, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(5))
+	require.True(t, restored.AllowN(4))
+}
+
+func TestGCRALimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	// 10 requests/second, burst of 5
+	lim := bucket.NewGCRALimiterWithClock(10, 5, clock)
+	require.True(t, lim.AllowN(5))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// State moves between the mutex and the lock-free implementation
+	lockFree := bucket.NewAtomicGCRALimiterWithClock(10, 5, clock)
+	require.NoError(t, json.Unmarshal(data, lockFree))
+	require.False(t, lockFree.Allow())
+
+	clock.advance(100 * time.Millisecond)
+	require.True(t, lockFree.Allow())
+
+	data, err = json.Marshal(lockFree)
+	require.NoError(t, err)
+
+	restored := bucket.NewGCRALimiterWithClock(10, 5, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.Allow())
+}
+
+func TestAtomicGCRALimiter_MarshalJSON_Fresh(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewAtomicGCRALimiterWithClock(10, 5, clock)
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	restored := bucket.NewAtomicGCRALimiterWithClock(10, 5, clock)
+	require.True(t, restored.AllowN(5))
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.True(t, restored.AllowN(5))
+}
+
+func TestUnmarshalJSON_WrongLimiter(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(bucket.NewTokenLimiter(1, 1))
+	require.NoError(t, err)
+
+	require.Error(t, json.Unmarshal(data, bucket.NewLeakyLimiter(1, 1)))
+	require.Error(t, json.Unmarshal(data, bucket.NewGCRALimiter(1, 1)))
+	require.Error(t, json.Unmarshal(data, bucket.NewAtomicGCRALimiter(1, 1)))
+
+	data, err = json.Marshal(bucket.NewGCRALimiter(1, 1))
+	require.NoError(t, err)
+	require.Error(t, json.Unmarshal(data, bucket.NewTokenLimiter(1, 1)))
+}
@@ -1,19 +1,15 @@
 package bucket
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
-)
-
-type clock interface {
-	Now() time.Time
-}
 
-type realClock struct{}
-
-func (c realClock) Now() time.Time {
-	return time.Now()
-}
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
+)
 
 // TokenLimiter implements a bucket rate limiter. It allows a burst of
 // requests up to capacity, then refills tokens at the specified rate per second.
@@ -21,18 +17,18 @@ type TokenLimiter struct {
 	mu                     sync.Mutex
 	capacity, tokens, rate float64
 	lastRefillAt           time.Time
-	clock                  clock
+	clock                  clock.Clock
 }
 
 // NewTokenLimiter creates a new rate limiter with the given capacity and refill rate.
 // Capacity is the maximum burst size. Rate is tokens added per second.
 func NewTokenLimiter(capacity, rate uint32) *TokenLimiter {
-	return NewLimiterWithClock(capacity, rate, realClock{})
+	return NewLimiterWithClock(capacity, rate, clock.Real{})
 }
 
 // NewLimiterWithClock creates a new rate limiter with a custom clock.
 // Use this constructor for testing with a mock clock.
-func NewLimiterWithClock(capacity, rate uint32, clock clock) *TokenLimiter {
+func NewLimiterWithClock(capacity, rate uint32, clock clock.Clock) *TokenLimiter {
 	return &TokenLimiter{
 		capacity:     float64(capacity),
 		tokens:       float64(capacity),
@@ -46,18 +42,190 @@ func NewLimiterWithClock(capacity, rate uint32, clock clock) *TokenLimiter {
 // available and returns true. If no tokens are available, it returns false
 // without blocking.
 func (lim *TokenLimiter) Allow() bool {
+	return lim.AllowN(1)
+}
+
+// AllowN reports whether n tokens may be consumed at once. It consumes all n
+// tokens and returns true if they are available, otherwise it consumes
+// nothing and returns false. AllowN(0) always returns true.
+func (lim *TokenLimiter) AllowN(n uint32) bool {
+	return lim.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the bucket state.
+func (lim *TokenLimiter) Decide() rate.Decision {
+	return lim.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the bucket state. Remaining is the
+// number of whole tokens left and ResetAt is when the bucket will be full.
+func (lim *TokenLimiter) DecideN(n uint32) rate.Decision {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	delay, err := lim.take(n)
+	d := rate.Decision{
+		Allowed:    err == nil && delay == 0,
+		Limit:      uint32(lim.capacity),
+		Remaining:  uint32(max(0, lim.tokens)),
+		RetryAfter: retryAfter(delay, err),
+	}
+
+	switch {
+	case lim.tokens >= lim.capacity:
+		d.ResetAt = lim.lastRefillAt
+	case lim.rate > 0:
+		d.ResetAt = lim.lastRefillAt.Add(clock.Seconds((lim.capacity - lim.tokens) / lim.rate))
+	}
+
+	return d
+}
+
+// RecoveredAt returns when the bucket will be full again. ok is false if it is
+// not full and never refills.
+func (lim *TokenLimiter) RecoveredAt() (time.Time, bool) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	switch {
+	case lim.tokens >= lim.capacity:
+		return lim.lastRefillAt, true
+	case lim.rate > 0:
+		return lim.lastRefillAt.Add(clock.Seconds((lim.capacity - lim.tokens) / lim.rate)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// MarshalJSON encodes the bucket's tokens and last refill time.
+func (lim *TokenLimiter) MarshalJSON() ([]byte, error) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	return json.Marshal(tokenState{Tokens: lim.tokens, LastRefillAt: lim.lastRefillAt})
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the bucket's
+// capacity and rate. Tokens above the capacity are discarded.
+func (lim *TokenLimiter) UnmarshalJSON(data []byte) error {
+	var s tokenState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.tokens = min(lim.capacity, s.Tokens)
+	lim.lastRefillAt = state.NotAfter(s.LastRefillAt, lim.clock.Now())
+
+	return nil
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (lim *TokenLimiter) Wait(ctx context.Context) error {
+	return lim.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are available and consumes them. It returns
+// ErrExceedsBurst if n is larger than capacity, ErrWouldExceedDeadline if the
+// token deficit cannot be refilled before the ctx deadline, or the ctx error
+// if ctx is done while waiting.
+func (lim *TokenLimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, lim.clock, func() (time.Duration, error) {
+		lim.mu.Lock()
+		defer lim.mu.Unlock()
+
+		return lim.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// Reserve is shorthand for ReserveN(1).
+func (lim *TokenLimiter) Reserve() *Reservation {
+	return lim.ReserveN(1)
+}
+
+// ReserveN takes n tokens now, even if that leaves the bucket in deficit, and
+// returns a Reservation whose Delay is the time until the deficit is refilled.
+// While in deficit, Allow and Wait callers queue behind the reservation.
+// The reservation is not OK, and nothing is taken, if n exceeds capacity or
+// the bucket never refills.
+func (lim *TokenLimiter) ReserveN(n uint32) *Reservation {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
 	lim.refill()
 
-	if lim.tokens >= 1 {
-		lim.tokens--
+	r := &Reservation{n: n, clock: lim.clock, refund: lim.RefundN, timeToAct: lim.lastRefillAt}
+
+	if float64(n) > lim.capacity || (lim.tokens < float64(n) && lim.rate == 0) {
+		return r
+	}
+
+	lim.tokens -= float64(n)
+	r.ok = true
 
-		return true
+	if lim.tokens < 0 {
+		r.timeToAct = r.timeToAct.Add(clock.Seconds(-lim.tokens / lim.rate))
 	}
 
-	return false
+	return r
+}
+
+// RefundN puts back n tokens taken by AllowN, DecideN or WaitN, e.g. when the
+// request was denied by another limiter after all. Tokens above the capacity
+// are discarded.
+func (lim *TokenLimiter) RefundN(n uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.refill()
+	lim.tokens = min(lim.capacity, lim.tokens+float64(n))
+}
+
+// take consumes n tokens if available and returns a zero delay. Otherwise it
+// consumes nothing and returns the time until the deficit is refilled. Zero
+// tokens are always available, even while reservations leave a deficit.
+// The caller must hold lim.mu.
+func (lim *TokenLimiter) take(n uint32) (time.Duration, error) {
+	lim.refill()
+
+	if n == 0 || lim.tokens >= float64(n) {
+		lim.tokens -= float64(n)
+
+		return 0, nil
+	}
+
+	if float64(n) > lim.capacity {
+		return 0, ErrExceedsBurst
+	}
+
+	if lim.rate == 0 {
+		return clock.Forever, nil
+	}
+
+	return clock.Seconds((float64(n) - lim.tokens) / lim.rate), nil
+}
+
+// SetCapacity changes the maximum burst size. Tokens above the new capacity
+// are discarded; the bucket otherwise keeps its current level.
+func (lim *TokenLimiter) SetCapacity(capacity uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.refill()
+	lim.capacity = float64(capacity)
+	lim.tokens = min(lim.capacity, lim.tokens)
+}
+
+// SetRate changes the number of tokens added per second. Tokens accrued so
+// far are credited at the old rate before the change takes effect.
+func (lim *TokenLimiter) SetRate(rate uint32) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.refill()
+	lim.rate = float64(rate)
 }
 
 func (lim *TokenLimiter) refill() {
@@ -146,3 +146,156 @@ func TestLimiter_Allow_ConcurrentWithRefill(t *testing.T) {
 
 	require.Equal(t, int64(10), allowed.Load())
 }
+
+func TestLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(10, 2, clock)
+
+	require.True(t, lim.AllowN(6))
+	require.True(t, lim.AllowN(0))
+
+	// Only 4 tokens left - a request for 5 is rejected and consumes nothing
+	require.False(t, lim.AllowN(5))
+	require.True(t, lim.AllowN(4))
+	require.False(t, lim.AllowN(1))
+
+	// Advance 2 seconds - refills 4 tokens
+	clock.advance(2 * time.Second)
+	require.False(t, lim.AllowN(5))
+	require.True(t, lim.AllowN(4))
+
+	// More than capacity can never be allowed
+	clock.advance(time.Hour)
+	require.False(t, lim.AllowN(11))
+	require.True(t, lim.AllowN(10))
+}
+
+func TestLimiter_AllowN_ZeroInDeficit(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewLimiterWithClock(2, 1, &testClock{now: time.Now()})
+
+	lim.ReserveN(2)
+	lim.ReserveN(1)
+
+	require.True(t, lim.AllowN(0))
+	require.False(t, lim.AllowN(1))
+}
+
+func TestLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(4, 2, clock)
+
+	d := lim.DecideN(3)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(4), d.Limit)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, clock.now.Add(1500*time.Millisecond), d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+
+	// Deficit of one token at 2 tokens/second
+	d = lim.DecideN(2)
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, 500*time.Millisecond, d.RetryAfter)
+
+	// Can never be allowed
+	d = lim.DecideN(5)
+	require.False(t, d.Allowed)
+	require.Zero(t, d.RetryAfter)
+
+	// Full bucket resets now
+	clock.advance(time.Hour)
+	require.Equal(t, clock.now, lim.DecideN(0).ResetAt)
+}
+
+func TestLimiter_Decide_ZeroRate(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(1, 0)
+
+	d := lim.Decide()
+	require.True(t, d.Allowed)
+	require.Zero(t, d.ResetAt)
+
+	d = lim.Decide()
+	require.False(t, d.Allowed)
+	require.Zero(t, d.RetryAfter)
+}
+
+func TestLimiter_SetCapacity(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(10, 1, clock)
+
+	// Shrinking discards tokens above the new capacity
+	lim.SetCapacity(3)
+	require.False(t, lim.AllowN(4))
+	require.True(t, lim.AllowN(3))
+
+	// Growing keeps the current level and refills up to the new capacity
+	lim.SetCapacity(5)
+	require.False(t, lim.Allow())
+	clock.advance(time.Hour)
+	require.True(t, lim.AllowN(5))
+}
+
+func TestLimiter_SetRate(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(10, 1, clock)
+	require.True(t, lim.AllowN(10))
+
+	// 2 seconds at the old rate, then 2 seconds at the new rate
+	clock.advance(2 * time.Second)
+	lim.SetRate(3)
+	clock.advance(2 * time.Second)
+
+	require.False(t, lim.AllowN(9))
+	require.True(t, lim.AllowN(8))
+}
+
+func TestLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(4, 2, clock)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now, at)
+
+	require.True(t, lim.AllowN(3))
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now.Add(1500*time.Millisecond), at)
+
+	// A drained bucket that never refills never recovers
+	lim = bucket.NewLimiterWithClock(1, 0, clock)
+	require.True(t, lim.Allow())
+
+	_, ok = lim.RecoveredAt()
+	require.False(t, ok)
+}
+
+func TestLimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	lim := bucket.NewLimiterWithClock(4, 0, clock)
+
+	require.True(t, lim.AllowN(3))
+	lim.RefundN(2)
+	require.Equal(t, uint32(3), lim.DecideN(0).Remaining)
+
+	// Tokens above the capacity are discarded
+	lim.RefundN(5)
+	require.Equal(t, uint32(4), lim.DecideN(0).Remaining)
+}
@@ -0,0 +1,28 @@
+package bucket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+var (
+	// ErrExceedsBurst is returned by WaitN when n is larger than the limiter's
+	// burst, so the request could never be satisfied no matter how long it waits.
+	ErrExceedsBurst = errors.New("bucket: n exceeds limiter burst")
+
+	// ErrWouldExceedDeadline is returned by WaitN when the time until the next
+	// permit is later than the context deadline.
+	ErrWouldExceedDeadline = errors.New("bucket: wait would exceed context deadline")
+)
+
+// retryAfter converts the result of a take to Decision.RetryAfter, which is
+// zero for allowed requests and for requests that can never be allowed.
+func retryAfter(delay time.Duration, err error) time.Duration {
+	if err != nil || delay == clock.Forever {
+		return 0
+	}
+
+	return delay
+}
@@ -0,0 +1,154 @@
+package bucket_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/ratetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Now())
+	lim := bucket.NewLimiterWithClock(2, 2, clock)
+
+	// Tokens available - no sleeping
+	require.NoError(t, lim.WaitN(t.Context(), 2))
+	require.Zero(t, clock.Timers())
+
+	// Deficit of one token at 2 tokens/second
+	done := ratetest.WaitAsync(func() error { return lim.Wait(t.Context()) })
+	ratetest.RequireWokenAfter(t, clock, done, 500*time.Millisecond)
+	require.False(t, lim.Allow())
+
+	// Deficit of two tokens
+	done = ratetest.WaitAsync(func() error { return lim.WaitN(t.Context(), 2) })
+	ratetest.RequireWokenAfter(t, clock, done, time.Second)
+}
+
+func TestLimiter_WaitN_ExceedsBurst(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(2, 2)
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 3), bucket.ErrExceedsBurst)
+	require.True(t, lim.AllowN(2))
+}
+
+func TestLimiter_WaitN_Deadline(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(1, 1)
+	require.True(t, lim.Allow())
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	// A one second deficit can't be met within 100ms - fail without waiting
+	start := time.Now()
+
+	require.ErrorIs(t, lim.Wait(ctx), bucket.ErrWouldExceedDeadline)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestLimiter_WaitN_ZeroRate(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Now())
+	lim := bucket.NewLimiterWithClock(1, 0, clock)
+	require.True(t, lim.Allow())
+
+	// Never refills, so any deadline is too short
+	ctx, cancel := context.WithTimeout(t.Context(), time.Hour)
+	defer cancel()
+
+	require.ErrorIs(t, lim.Wait(ctx), bucket.ErrWouldExceedDeadline)
+
+	// Without a deadline it blocks until cancelled
+	ctx, cancel = context.WithCancel(t.Context())
+	done := ratetest.WaitAsync(func() error { return lim.Wait(ctx) })
+
+	clock.BlockUntil(1)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.Zero(t, clock.Timers())
+}
+
+func TestLimiter_WaitN_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(1, 1)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	require.ErrorIs(t, lim.Wait(ctx), context.Canceled)
+	require.True(t, lim.Allow(), "cancelled wait must not consume")
+}
+
+func TestLimiter_Wait_RealClock(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewTokenLimiter(1, 100)
+	require.True(t, lim.Allow())
+
+	start := time.Now()
+
+	require.NoError(t, lim.Wait(t.Context()))
+	require.GreaterOrEqual(t, time.Since(start), 5*time.Millisecond)
+}
+
+func TestLeakyLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Now())
+	lim := bucket.NewLeakyLimiterWithClock(4, 2, clock) // drains 2 per second
+
+	require.NoError(t, lim.WaitN(t.Context(), 3))
+	require.Zero(t, clock.Timers())
+
+	// Level 3 of 4 - room for one, two more must drain first
+	done := ratetest.WaitAsync(func() error { return lim.WaitN(t.Context(), 3) })
+	ratetest.RequireWokenAfter(t, clock, done, time.Second)
+	require.False(t, lim.Allow())
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 5), bucket.ErrExceedsBurst)
+}
+
+func TestLeakyLimiter_Wait_ZeroRate(t *testing.T) {
+	t.Parallel()
+
+	lim := bucket.NewLeakyLimiter(1, 0)
+	require.NoError(t, lim.Wait(t.Context()))
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Hour)
+	defer cancel()
+
+	require.ErrorIs(t, lim.Wait(ctx), bucket.ErrWouldExceedDeadline)
+}
+
+func TestGCRALimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Now())
+	// 10 requests/second, burst of 3
+	lim := bucket.NewGCRALimiterWithClock(10, 3, clock)
+
+	require.NoError(t, lim.WaitN(t.Context(), 3))
+	require.Zero(t, clock.Timers())
+
+	// Burst exhausted - next conforming arrival is one emission away
+	done := ratetest.WaitAsync(func() error { return lim.Wait(t.Context()) })
+	ratetest.RequireWokenAfter(t, clock, done, 100*time.Millisecond)
+	require.False(t, lim.Allow())
+
+	// Two requests need two more emissions
+	done = ratetest.WaitAsync(func() error { return lim.WaitN(t.Context(), 2) })
+	ratetest.RequireWokenAfter(t, clock, done, 200*time.Millisecond)
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 4), bucket.ErrExceedsBurst)
+}
@@ -0,0 +1,122 @@
+// Package clock abstracts time for the limiters in this module, so that tests
+// can drive them with a fake clock such as ratetest.Clock.
+package clock
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Forever is the delay until something that never happens, such as tokens
+// becoming available in a bucket that never refills.
+const Forever = time.Duration(math.MaxInt64)
+
+// Clock tells the current time. It is all a limiter needs to make decisions.
+type Clock interface {
+	Now() time.Time
+}
+
+// Timer is a one-shot timer. C delivers a single value once the timer fires;
+// Stop prevents it from firing and reports whether it was still pending.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// TimerClock is a Clock that can also create timers. Limiters use it to sleep
+// in Wait, so a fake TimerClock decides when waiting limiters wake up.
+type TimerClock interface {
+	Clock
+	NewTimer(d time.Duration) Timer
+}
+
+// Real is the system clock.
+type Real struct{}
+
+// Now returns time.Now().
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a Timer backed by time.NewTimer.
+func (Real) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// NewTimer creates a timer on c if it is a TimerClock, and a real timer
+// otherwise. Clocks that only implement Now still work, but sleep in real time.
+func NewTimer(c Clock, d time.Duration) Timer {
+	if tc, ok := c.(TimerClock); ok {
+		return tc.NewTimer(d)
+	}
+
+	return Real{}.NewTimer(d)
+}
+
+// Sleep blocks until d has passed on c or ctx is done, in which case it
+// returns the ctx error.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	t := NewTimer(c, d)
+	defer t.Stop()
+
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait calls take until it reports a zero delay, meaning the permits were
+// taken, sleeping on c for the reported delay in between, and returns take's
+// error if it fails. It returns errDeadline without sleeping if the delay is
+// known to outlast the ctx deadline, and the ctx error if ctx is done first.
+// Limiters use it to implement WaitN on top of a non-blocking take.
+func Wait(ctx context.Context, c Clock, take func() (time.Duration, error), errDeadline error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		delay, err := take()
+		if err != nil {
+			return err
+		}
+
+		if delay == 0 {
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && delay > time.Until(deadline) {
+			return errDeadline
+		}
+
+		if err := Sleep(ctx, c, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Seconds converts a fractional number of seconds to a duration, rounding up
+// so that sleeping for it never wakes too early. Durations too long to
+// represent are Forever.
+func Seconds(s float64) time.Duration {
+	if s >= Forever.Seconds() {
+		return Forever
+	}
+
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,128 @@
+package clock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/ratetest"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errDeadline = errors.New("deadline")
+	errTake     = errors.New("take")
+)
+
+// nowClock only implements Now, like a minimal user-provided mock.
+type nowClock struct{}
+
+func (nowClock) Now() time.Time {
+	return time.Now()
+}
+
+func TestReal_Now(t *testing.T) {
+	t.Parallel()
+
+	before := time.Now()
+	now := clock.Real{}.Now()
+
+	require.False(t, now.Before(before))
+}
+
+func TestReal_NewTimer(t *testing.T) {
+	t.Parallel()
+
+	timer := clock.Real{}.NewTimer(time.Millisecond)
+	<-timer.C()
+	require.False(t, timer.Stop())
+
+	timer = clock.Real{}.NewTimer(time.Hour)
+	require.True(t, timer.Stop())
+}
+
+func TestNewTimer_FallsBackToRealTimer(t *testing.T) {
+	t.Parallel()
+
+	timer := clock.NewTimer(nowClock{}, time.Millisecond)
+	<-timer.C()
+}
+
+func TestSleep(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, clock.Sleep(t.Context(), clock.Real{}, time.Millisecond))
+}
+
+func TestSleep_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	require.ErrorIs(t, clock.Sleep(ctx, nowClock{}, time.Hour), context.Canceled)
+}
+
+func TestWait(t *testing.T) {
+	t.Parallel()
+
+	fake := ratetest.NewClock(time.Now())
+	delays := []time.Duration{time.Second, time.Minute, 0}
+	takes := 0
+
+	done := ratetest.WaitAsync(func() error {
+		return clock.Wait(t.Context(), fake, func() (time.Duration, error) {
+			d := delays[takes]
+			takes++
+
+			return d, nil
+		}, errDeadline)
+	})
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	ratetest.RequireWokenAfter(t, fake, done, time.Minute)
+	require.Equal(t, 3, takes)
+}
+
+func TestWait_Errors(t *testing.T) {
+	t.Parallel()
+
+	take := func() (time.Duration, error) { return time.Hour, nil }
+
+	// The deadline is known to pass first
+	ctx, cancel := context.WithTimeout(t.Context(), time.Minute)
+	defer cancel()
+
+	require.ErrorIs(t, clock.Wait(ctx, nowClock{}, take, errDeadline), errDeadline)
+
+	// take fails
+	err := clock.Wait(t.Context(), nowClock{}, func() (time.Duration, error) {
+		return 0, errTake
+	}, errDeadline)
+	require.ErrorIs(t, err, errTake)
+
+	// ctx is done, before or while sleeping
+	ctx, cancel = context.WithCancel(t.Context())
+	cancel()
+
+	require.ErrorIs(t, clock.Wait(ctx, nowClock{}, take, errDeadline), context.Canceled)
+
+	ctx, cancel = context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	fake := ratetest.NewClock(time.Now())
+	err = clock.Wait(ctx, fake, func() (time.Duration, error) { return time.Millisecond, nil }, errDeadline)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSeconds(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 1500*time.Millisecond, clock.Seconds(1.5))
+	require.Equal(t, time.Duration(1), clock.Seconds(1e-10)) // Rounded up
+	require.Zero(t, clock.Seconds(0))
+	require.Equal(t, clock.Forever, clock.Seconds(1e12))
+}
@@ -0,0 +1,173 @@
+// Package composite combines several limiters into one that allows a request
+// only if all of them do, e.g. to enforce 10 requests per second and 500 per
+// hour and 10,000 per day for the same key.
+package composite
+
+import (
+	"time"
+
+	"github.com/serroba/rate"
+)
+
+// Refunder is a limiter that can give back requests it allowed. The limiters
+// of the bucket and window packages implement it.
+type Refunder interface {
+	DecideN(n uint32) rate.Decision
+	RefundN(n uint32)
+}
+
+// Limit is one of the limits of a composite limiter. Its name is reported
+// when it denies a request.
+type Limit struct {
+	Name    string
+	Limiter Refunder
+}
+
+// Decision is the outcome of a composite limiter's check.
+type Decision struct {
+	rate.Decision
+	// Denied holds the names of the limits that denied the request, in the
+	// order the limits were given. It is empty if the request was allowed.
+	Denied []string
+}
+
+// Limiter allows a request only if all of its limits allow it. Every limit is
+// asked in turn and, if any of them denies, the ones that allowed are refunded,
+// so a denied request counts against none of them.
+//
+// Limits are asked one after the other without a lock held across them, so
+// while requests race for the last few slots of one limit they may see each
+// other's requests before those are refunded and be denied even though they
+// would have fit. No limit is ever exceeded.
+type Limiter struct {
+	limits []Limit
+}
+
+// New creates a limiter that allows a request only if all limits do. A limiter
+// without limits allows everything.
+func New(limits ...Limit) *Limiter {
+	return &Limiter{limits: limits}
+}
+
+// Allow reports whether a request is allowed by all limits.
+func (l *Limiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests are allowed by all limits at once. They
+// are counted against all limits if so, and against none otherwise.
+func (l *Limiter) AllowN(n uint32) bool {
+	return l.CheckN(n).Allowed
+}
+
+// Decide is like Allow but also reports the state of the limits.
+func (l *Limiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the state of the limits; see
+// CheckN.
+func (l *Limiter) DecideN(n uint32) rate.Decision {
+	return l.CheckN(n).Decision
+}
+
+// Check is like Decide but also reports which limits denied the request.
+func (l *Limiter) Check() Decision {
+	return l.CheckN(1)
+}
+
+// CheckN is like DecideN but also reports which limits denied the requests.
+// Limit and Remaining are those of the limit with the fewest requests
+// remaining, ResetAt is when all limits will have recovered and RetryAfter is
+// the longest wait of the limits that denied.
+func (l *Limiter) CheckN(n uint32) Decision {
+	ds := make([]rate.Decision, len(l.limits))
+
+	var denied []string
+
+	for i, lim := range l.limits {
+		ds[i] = lim.Limiter.DecideN(n)
+		if !ds[i].Allowed {
+			denied = append(denied, lim.Name)
+		}
+	}
+
+	if denied != nil {
+		for i, lim := range l.limits {
+			if ds[i].Allowed {
+				lim.Limiter.RefundN(n)
+				ds[i].Remaining = min(ds[i].Limit, ds[i].Remaining+n)
+			}
+		}
+	}
+
+	return Decision{Decision: combine(ds), Denied: denied}
+}
+
+// combine merges the decisions of all limits into one.
+func combine(ds []rate.Decision) rate.Decision {
+	d := rate.Decision{Allowed: true}
+
+	// Whether all limits recover, and whether all denials can be allowed.
+	recovers, retries := true, true
+
+	for i, c := range ds {
+		if i == 0 || c.Remaining < d.Remaining {
+			d.Limit, d.Remaining = c.Limit, c.Remaining
+		}
+
+		if c.ResetAt.After(d.ResetAt) {
+			d.ResetAt = c.ResetAt
+		}
+
+		recovers = recovers && !c.ResetAt.IsZero()
+
+		if !c.Allowed {
+			d.Allowed = false
+			d.RetryAfter = max(d.RetryAfter, c.RetryAfter)
+			retries = retries && c.RetryAfter > 0
+		}
+	}
+
+	if !recovers {
+		d.ResetAt = time.Time{}
+	}
+
+	if !retries {
+		d.RetryAfter = 0
+	}
+
+	return d
+}
+
+// RefundN gives back n requests allowed by AllowN, DecideN or CheckN to all
+// limits, so that a composite limiter can itself be a limit of another one.
+func (l *Limiter) RefundN(n uint32) {
+	for _, lim := range l.limits {
+		lim.Limiter.RefundN(n)
+	}
+}
+
+// RecoveredAt returns when all limits will have recovered. ok is false if one
+// of them never recovers or cannot tell.
+func (l *Limiter) RecoveredAt() (time.Time, bool) {
+	var at time.Time
+
+	for _, lim := range l.limits {
+		r, ok := lim.Limiter.(interface{ RecoveredAt() (time.Time, bool) })
+		if !ok {
+			return time.Time{}, false
+		}
+
+		t, ok := r.RecoveredAt()
+		if !ok {
+			return time.Time{}, false
+		}
+
+		if t.After(at) {
+			at = t
+		}
+	}
+
+	return at, true
+}
@@ -0,0 +1,251 @@
+package composite_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/composite"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestLimiter_AllowsOnlyIfAllAllow(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+	second := window.NewFixedLimiterWithClock(2, time.Second, clock)
+	hour := window.NewFixedLimiterWithClock(3, time.Hour, clock)
+
+	lim := composite.New(
+		composite.Limit{Name: "second", Limiter: second},
+		composite.Limit{Name: "hour", Limiter: hour},
+	)
+
+	require.True(t, lim.Allow())
+	require.True(t, lim.Allow())
+
+	d := lim.Check()
+	require.False(t, d.Allowed)
+	require.Equal(t, []string{"second"}, d.Denied)
+	require.Equal(t, time.Second, d.RetryAfter)
+
+	// The hourly limit was refunded
+	require.Equal(t, uint32(1), hour.DecideN(0).Remaining)
+
+	clock.Advance(time.Second)
+
+	require.True(t, lim.Allow())
+
+	d = lim.Check()
+	require.False(t, d.Allowed)
+	require.Equal(t, []string{"hour"}, d.Denied)
+	require.Equal(t, time.Hour-time.Second, d.RetryAfter)
+
+	// The per-second limit was refunded
+	require.Equal(t, uint32(1), second.DecideN(0).Remaining)
+}
+
+func TestLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+
+	lim := composite.New(
+		composite.Limit{Name: "burst", Limiter: bucket.NewLimiterWithClock(10, 10, clock)},
+		composite.Limit{Name: "minute", Limiter: window.NewFixedLimiterWithClock(3, time.Minute, clock)},
+	)
+
+	// The limit with the fewest requests remaining is reported
+	d := lim.Decide()
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+	require.Equal(t, uint32(2), d.Remaining)
+	require.Equal(t, epoch.Add(time.Minute), d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+
+	// Denied requests are refunded in the reported state as well
+	d = lim.DecideN(3)
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(2), d.Remaining)
+	require.Equal(t, epoch.Add(time.Minute), d.ResetAt)
+	require.Equal(t, time.Minute, d.RetryAfter)
+}
+
+func TestLimiter_Check_AllDenying(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+
+	lim := composite.New(
+		composite.Limit{Name: "second", Limiter: window.NewFixedLimiterWithClock(1, time.Second, clock)},
+		composite.Limit{Name: "minute", Limiter: window.NewFixedLimiterWithClock(1, time.Minute, clock)},
+	)
+
+	require.True(t, lim.Allow())
+
+	// The longest wait is reported
+	d := lim.Check()
+	require.False(t, d.Allowed)
+	require.Equal(t, []string{"second", "minute"}, d.Denied)
+	require.Equal(t, time.Minute, d.RetryAfter)
+}
+
+func TestLimiter_Check_Never(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+
+	lim := composite.New(
+		composite.Limit{Name: "quota", Limiter: bucket.NewLimiterWithClock(1, 0, clock)},
+		composite.Limit{Name: "minute", Limiter: window.NewFixedLimiterWithClock(1, time.Minute, clock)},
+	)
+
+	require.True(t, lim.Allow())
+
+	// The quota never refills, so no wait helps
+	d := lim.Check()
+	require.False(t, d.Allowed)
+	require.Equal(t, []string{"quota", "minute"}, d.Denied)
+	require.Zero(t, d.RetryAfter)
+	require.Zero(t, d.ResetAt)
+
+	// More than a limit admits at once is never allowed either
+	d = lim.CheckN(2)
+	require.Equal(t, []string{"quota", "minute"}, d.Denied)
+	require.Zero(t, d.RetryAfter)
+}
+
+func TestLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+	sliding := window.NewSlidingLimiterWithClock(5, time.Minute, clock)
+	counter := window.NewSlidingCounterLimiterWithClock(5, time.Minute, clock)
+	gcra := bucket.NewGCRALimiterWithClock(1, 4, clock)
+
+	lim := composite.New(
+		composite.Limit{Name: "sliding", Limiter: sliding},
+		composite.Limit{Name: "counter", Limiter: counter},
+		composite.Limit{Name: "gcra", Limiter: gcra},
+	)
+
+	require.True(t, lim.AllowN(3))
+	require.False(t, lim.AllowN(2))
+
+	// Only the GCRA limit denied, the others were refunded
+	require.Equal(t, uint32(2), sliding.DecideN(0).Remaining)
+	require.Equal(t, uint32(2), counter.DecideN(0).Remaining)
+	require.True(t, lim.AllowN(0))
+}
+
+func TestLimiter_Nested(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+	inner := composite.New(
+		composite.Limit{Name: "second", Limiter: window.NewFixedLimiterWithClock(5, time.Second, clock)},
+		composite.Limit{Name: "leaky", Limiter: bucket.NewLeakyLimiterWithClock(5, 1, clock)},
+	)
+	day := window.NewFixedLimiterWithClock(2, 24*time.Hour, clock)
+
+	lim := composite.New(
+		composite.Limit{Name: "inner", Limiter: inner},
+		composite.Limit{Name: "day", Limiter: day},
+	)
+
+	require.True(t, lim.AllowN(2))
+	require.Equal(t, []string{"day"}, lim.Check().Denied)
+	require.Equal(t, uint32(3), inner.DecideN(0).Remaining)
+}
+
+func TestLimiter_Empty(t *testing.T) {
+	t.Parallel()
+
+	lim := composite.New()
+
+	d := lim.Check()
+	require.True(t, d.Allowed)
+	require.Empty(t, d.Denied)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Zero(t, at)
+}
+
+func TestLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+
+	lim := composite.New(
+		composite.Limit{Name: "second", Limiter: window.NewFixedLimiterWithClock(5, time.Second, clock)},
+		composite.Limit{Name: "minute", Limiter: window.NewFixedLimiterWithClock(5, time.Minute, clock)},
+	)
+
+	require.True(t, lim.Allow())
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, epoch.Add(time.Minute), at)
+
+	lim = composite.New(
+		composite.Limit{Name: "quota", Limiter: bucket.NewLimiterWithClock(5, 0, clock)},
+	)
+	require.True(t, lim.Allow())
+
+	_, ok = lim.RecoveredAt()
+	require.False(t, ok)
+
+	lim = composite.New(composite.Limit{Name: "plain", Limiter: plain{}})
+
+	_, ok = lim.RecoveredAt()
+	require.False(t, ok)
+}
+
+// plain is a limit that cannot tell when it recovers.
+type plain struct{}
+
+func (plain) DecideN(uint32) rate.Decision { return rate.Decision{Allowed: true} }
+
+func (plain) RefundN(uint32) {}
+
+func TestLimiter_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(epoch)
+	second := window.NewFixedLimiterWithClock(50, time.Second, clock)
+	hour := bucket.NewLimiterWithClock(80, 0, clock)
+
+	lim := composite.New(
+		composite.Limit{Name: "second", Limiter: second},
+		composite.Limit{Name: "hour", Limiter: hour},
+	)
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int32
+	)
+
+	for range 200 {
+		wg.Go(func() {
+			if lim.Allow() {
+				allowed.Add(1)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	// Never more than the tightest limit, and every denial was refunded
+	n := uint32(allowed.Load())
+	require.LessOrEqual(t, n, uint32(50))
+	require.Equal(t, 50-n, second.DecideN(0).Remaining)
+	require.Equal(t, 80-n, hour.DecideN(0).Remaining)
+}
@@ -0,0 +1,92 @@
+// Package concurrency limits how many operations are in flight at the same
+// time, as opposed to how many start per unit of time.
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a semaphore that admits at most limit concurrent holders.
+// Each successful acquisition must be released once the work is done.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter creates a limiter admitting at most limit operations in flight.
+func NewLimiter(limit uint32) *Limiter {
+	return &Limiter{sem: make(chan struct{}, limit)}
+}
+
+// TryAcquire takes a slot if one is free without blocking. On success it
+// returns a release function that frees the slot; calling it more than once
+// has no effect.
+func (l *Limiter) TryAcquire() (func(), bool) {
+	if !l.Allow() {
+		return nil, false
+	}
+
+	return l.releaseFunc(), true
+}
+
+// Acquire blocks until a slot is free or ctx is done. On success it returns a
+// release function that frees the slot; calling it more than once has no effect.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Allow takes a slot if one is free and reports whether it did. Unlike
+// TryAcquire the slot is not tied to a release function: the caller must call
+// Release exactly once when done. A registry never calls it, as nothing would
+// release the slot: see registry.Acquirer.
+func (l *Limiter) Allow() bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken by Allow. It panics if no slot is held.
+func (l *Limiter) Release() {
+	select {
+	case <-l.sem:
+	default:
+		panic("concurrency: Release without a held slot")
+	}
+}
+
+// InFlight returns the number of slots currently held.
+func (l *Limiter) InFlight() int {
+	return len(l.sem)
+}
+
+// Limit returns the maximum number of slots.
+func (l *Limiter) Limit() int {
+	return cap(l.sem)
+}
+
+// RecoveredAt reports whether no slot is held. Slots are only freed by
+// releasing them, never by time passing, so the returned time is always zero.
+func (l *Limiter) RecoveredAt() (time.Time, bool) {
+	return time.Time{}, l.InFlight() == 0
+}
+
+func (l *Limiter) releaseFunc() func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(l.Release)
+	}
+}
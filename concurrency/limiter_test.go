@@ -0,0 +1,178 @@
+package concurrency_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/concurrency"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_TryAcquire(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(2)
+	require.Equal(t, 2, lim.Limit())
+
+	release1, ok := lim.TryAcquire()
+	require.True(t, ok)
+
+	release2, ok := lim.TryAcquire()
+	require.True(t, ok)
+	require.Equal(t, 2, lim.InFlight())
+
+	_, ok = lim.TryAcquire()
+	require.False(t, ok)
+
+	// Releasing twice only frees one slot
+	release1()
+	release1()
+	require.Equal(t, 1, lim.InFlight())
+
+	_, ok = lim.TryAcquire()
+	require.True(t, ok)
+
+	_, ok = lim.TryAcquire()
+	require.False(t, ok)
+
+	release2()
+	require.Equal(t, 1, lim.InFlight())
+}
+
+func TestLimiter_ZeroLimit(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(0)
+
+	_, ok := lim.TryAcquire()
+	require.False(t, ok)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := lim.Acquire(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLimiter_Acquire_BlocksUntilRelease(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(1)
+
+	release, err := lim.Acquire(t.Context())
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+
+	go func() {
+		r, err := lim.Acquire(t.Context())
+		if err == nil {
+			acquired <- r
+		}
+	}()
+
+	select {
+	case <-acquired:
+		require.Fail(t, "acquired while the slot was held")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+
+	(<-acquired)()
+	require.Zero(t, lim.InFlight())
+}
+
+func TestLimiter_Acquire_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(1)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	// Cancelled contexts fail even if a slot is free
+	_, err := lim.Acquire(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, lim.InFlight())
+}
+
+func TestLimiter_AllowRelease(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(1)
+
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	lim.Release()
+	require.True(t, lim.Allow())
+
+	lim.Release()
+	require.Panics(t, lim.Release)
+}
+
+func TestLimiter_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(5)
+
+	var (
+		inFlight, peak atomic.Int64
+		wg             sync.WaitGroup
+	)
+
+	for range 50 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			release, err := lim.Acquire(t.Context())
+			if err != nil {
+				return
+			}
+			defer release()
+
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+
+			time.Sleep(time.Millisecond)
+			inFlight.Add(-1)
+		}()
+	}
+
+	wg.Wait()
+
+	require.LessOrEqual(t, peak.Load(), int64(5))
+	require.Zero(t, lim.InFlight())
+}
+
+func TestLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(2)
+
+	_, ok := lim.RecoveredAt()
+	require.True(t, ok)
+
+	release, ok := lim.TryAcquire()
+	require.True(t, ok)
+
+	// Held slots only come back through release
+	_, ok = lim.RecoveredAt()
+	require.False(t, ok)
+
+	release()
+
+	_, ok = lim.RecoveredAt()
+	require.True(t, ok)
+}
@@ -0,0 +1,25 @@
+// Package rate holds the types shared by the limiter implementations in the
+// bucket and window packages.
+package rate
+
+import "time"
+
+// Decision is the outcome of a rate limit check together with the limiter
+// state right after it. It carries what is needed to populate rate limit
+// response headers.
+type Decision struct {
+	// Allowed reports whether the request was allowed.
+	Allowed bool
+	// Limit is the most requests the limiter admits at once: the capacity or
+	// burst of a bucket, or the limit of a window.
+	Limit uint32
+	// Remaining is how many more requests would be allowed right now.
+	Remaining uint32
+	// ResetAt is when the limiter will have fully recovered, so that
+	// Remaining equals Limit. It is zero if the limiter never recovers.
+	ResetAt time.Time
+	// RetryAfter is how long until the denied request would be allowed.
+	// It is zero if the request was allowed, or if it can never be allowed
+	// because it exceeds Limit or the limiter never recovers.
+	RetryAfter time.Duration
+}
@@ -0,0 +1,150 @@
+// Package pool implements the connection pool of the redis and memcached
+// clients. Connections are dialed on demand, kept open between commands and
+// closed when a command is aborted, so the protocol packages only have to
+// write requests and parse replies.
+package pool
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxIdle is how many idle connections a Pool keeps open.
+	MaxIdle = 16
+	// DefaultTimeout is how long a command may take, including dialing,
+	// unless set otherwise with SetTimeout.
+	DefaultTimeout = time.Second
+)
+
+// Conn is a pooled connection with buffered reads and writes.
+type Conn struct {
+	net.Conn
+
+	R *bufio.Reader
+	W *bufio.Writer
+}
+
+// Pool keeps up to MaxIdle connections to a server open between commands and
+// opens more as needed. It is safe for concurrent use.
+type Pool struct {
+	addr      string
+	dialer    net.Dialer
+	timeout   time.Duration
+	errClosed error
+	reusable  func(error) bool
+
+	mu     sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+// New creates a pool of connections to addr. Do returns errClosed once the
+// pool is closed. reusable reports whether a connection whose command failed
+// with an error is still in a known state, e.g. after an error reply.
+func New(addr string, errClosed error, reusable func(error) bool) *Pool {
+	return &Pool{addr: addr, timeout: DefaultTimeout, errClosed: errClosed, reusable: reusable}
+}
+
+// SetTimeout sets how long a command may take, including dialing a
+// connection for it. A timeout of zero or less disables it.
+func (p *Pool) SetTimeout(d time.Duration) {
+	p.timeout = max(0, d)
+}
+
+// Do runs cmd on a pooled connection and returns its error. The connection
+// is closed and the command aborted with the ctx error when ctx is done or
+// the timeout has passed, so a stalled server cannot block callers whose
+// context never ends. Connections left in an unknown state are closed.
+func (p *Pool) Do(ctx context.Context, cmd func(*Conn) error) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	cn, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() { cn.Close() })
+
+	err = cmd(cn)
+	if !stop() {
+		return ctx.Err()
+	}
+
+	if err != nil && !p.reusable(err) {
+		cn.Close()
+
+		return err
+	}
+
+	p.put(cn)
+
+	return err
+}
+
+// Close closes the idle connections. Commands in flight finish on their own
+// connection, which is then closed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	var errs []error
+	for _, cn := range p.idle {
+		errs = append(errs, cn.Close())
+	}
+
+	p.idle = nil
+
+	return errors.Join(errs...)
+}
+
+func (p *Pool) get(ctx context.Context) (*Conn, error) {
+	p.mu.Lock()
+
+	if p.closed {
+		p.mu.Unlock()
+
+		return nil, p.errClosed
+	}
+
+	if n := len(p.idle); n > 0 {
+		cn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		return cn, nil
+	}
+
+	p.mu.Unlock()
+
+	nc, err := p.dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: nc, R: bufio.NewReader(nc), W: bufio.NewWriter(nc)}, nil
+}
+
+func (p *Pool) put(cn *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle) >= MaxIdle {
+		cn.Close()
+
+		return
+	}
+
+	p.idle = append(p.idle, cn)
+}
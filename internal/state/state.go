@@ -0,0 +1,33 @@
+// Package state holds the helpers the limiters of the bucket and window
+// packages use to save and restore their state.
+//
+// The limiters marshal their state, not their configuration, to JSON so it
+// can be restored into a limiter built with the current configuration, e.g.
+// after a restart. Times are stored as wall clock times, so time spent in
+// between counts as elapsed, as if the process had kept running.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Unmarshal decodes data into v, rejecting the state of other limiter types
+// instead of silently ignoring it.
+func Unmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v)
+}
+
+// NotAfter returns t, or now if t is later, so that state saved by a host
+// whose clock ran ahead does not hold the limiter back once restored.
+func NotAfter(t, now time.Time) time.Time {
+	if t.After(now) {
+		return now
+	}
+
+	return t
+}
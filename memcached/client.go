@@ -0,0 +1,221 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serroba/rate/internal/pool"
+)
+
+var (
+	// ErrClosed is returned by the Client after it has been closed.
+	ErrClosed = errors.New("memcached: client closed")
+	// ErrInvalidKey is returned for keys memcached does not accept: empty,
+	// longer than 250 bytes, or containing spaces or control characters.
+	ErrInvalidKey = errors.New("memcached: invalid key")
+)
+
+// Error is an error reply from the server, such as "CLIENT_ERROR bad data
+// chunk".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Counters is the part of a memcached client that the limiter uses. Client
+// implements it; other clients can be adapted to it.
+type Counters interface {
+	// Add stores value under key unless the key exists, and reports whether it
+	// stored it. The item expires after ttl.
+	Add(ctx context.Context, key string, value uint64, ttl time.Duration) (bool, error)
+	// Incr adds delta to the value under key and returns the result, or false
+	// if the key does not exist.
+	Incr(ctx context.Context, key string, delta uint64) (uint64, bool, error)
+	// Decr subtracts delta from the value under key, stopping at zero, and
+	// returns the result, or false if the key does not exist.
+	Decr(ctx context.Context, key string, delta uint64) (uint64, bool, error)
+}
+
+// Client is a minimal memcached text protocol client that is safe for
+// concurrent use. Connections are pooled between commands.
+type Client struct {
+	pool *pool.Pool
+}
+
+// DefaultTimeout is how long a command may take, including dialing, unless
+// set otherwise with WithTimeout.
+const DefaultTimeout = pool.DefaultTimeout
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets how long a command may take, including dialing, before it
+// is aborted with context.DeadlineExceeded, on top of the deadline of its
+// context. A timeout of zero or less disables it.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.pool.SetTimeout(d)
+	}
+}
+
+// relativeExpiry is the longest expiry memcached takes as relative seconds;
+// longer ones must be given as Unix times.
+const relativeExpiry = 30 * 24 * time.Hour
+
+// NewClient creates a client for the server at addr, such as
+// "localhost:11211". Connections are opened on first use. Commands time out
+// after DefaultTimeout unless set otherwise with WithTimeout.
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{pool: pool.New(addr, ErrClosed, isReply)}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Add stores value under key unless the key exists, and reports whether it
+// stored it. The item expires after ttl, rounded up to the second. A ttl of
+// up to 30 days is sent as is, so it does not depend on the server's clock;
+// longer ones are sent as a Unix time.
+func (c *Client) Add(ctx context.Context, key string, value uint64, ttl time.Duration) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+
+	exptime := max(1, int64((ttl+time.Second-1)/time.Second))
+	if ttl > relativeExpiry {
+		exptime = time.Now().Add(ttl).Unix()
+	}
+
+	data := strconv.FormatUint(value, 10)
+	cmd := fmt.Sprintf("add %s 0 %d %d\r\n%s\r\n", key, exptime, len(data), data)
+
+	reply, err := c.do(ctx, cmd)
+	if err != nil {
+		return false, err
+	}
+
+	switch reply {
+	case "STORED":
+		return true, nil
+	case "NOT_STORED":
+		return false, nil
+	default:
+		return false, fmt.Errorf("memcached: unexpected reply %q", reply)
+	}
+}
+
+// Incr adds delta to the value under key and returns the result, or false if
+// the key does not exist.
+func (c *Client) Incr(ctx context.Context, key string, delta uint64) (uint64, bool, error) {
+	return c.arith(ctx, "incr", key, delta)
+}
+
+// Decr subtracts delta from the value under key, stopping at zero, and
+// returns the result, or false if the key does not exist.
+func (c *Client) Decr(ctx context.Context, key string, delta uint64) (uint64, bool, error) {
+	return c.arith(ctx, "decr", key, delta)
+}
+
+func (c *Client) arith(ctx context.Context, op, key string, delta uint64) (uint64, bool, error) {
+	if err := checkKey(key); err != nil {
+		return 0, false, err
+	}
+
+	reply, err := c.do(ctx, fmt.Sprintf("%s %s %d\r\n", op, key, delta))
+	if err != nil {
+		return 0, false, err
+	}
+
+	if reply == "NOT_FOUND" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(reply, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("memcached: unexpected reply %q", reply)
+	}
+
+	return n, true, nil
+}
+
+// Close closes the idle connections. Commands in flight finish on their own
+// connection, which is then closed.
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
+
+// do sends cmd and returns the one-line reply. Error replies are returned as
+// an Error. The connection is closed and the command aborted when ctx is done
+// or the client's timeout has passed.
+func (c *Client) do(ctx context.Context, cmd string) (string, error) {
+	var reply string
+
+	err := c.pool.Do(ctx, func(cn *pool.Conn) error {
+		var err error
+
+		reply, err = roundTrip(cn, cmd)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return reply, nil
+}
+
+// roundTrip writes cmd to cn and reads the one-line reply.
+func roundTrip(cn *pool.Conn, cmd string) (string, error) {
+	if _, err := cn.W.WriteString(cmd); err != nil {
+		return "", err
+	}
+
+	if err := cn.W.Flush(); err != nil {
+		return "", err
+	}
+
+	line, err := cn.R.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	reply, ok := strings.CutSuffix(line, "\r\n")
+	if !ok {
+		return "", fmt.Errorf("memcached: malformed reply %q", line)
+	}
+
+	if reply == "ERROR" || strings.HasPrefix(reply, "CLIENT_ERROR ") || strings.HasPrefix(reply, "SERVER_ERROR ") {
+		return "", Error(reply)
+	}
+
+	return reply, nil
+}
+
+// isReply reports whether err is an error reply, after which the connection
+// can be reused.
+func isReply(err error) bool {
+	var e Error
+
+	return errors.As(err, &e)
+}
+
+func checkKey(key string) error {
+	if key == "" || len(key) > 250 {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	for i := range len(key) {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package memcached_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/memcached"
+	"github.com/serroba/rate/memcached/memcachedtest"
+	"github.com/serroba/rate/ratetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Counters(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	s, c := newServer(t, clock)
+
+	n, ok, err := c.Incr(t.Context(), "k", 1)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Zero(t, n)
+
+	stored, err := c.Add(t.Context(), "k", 5, 1500*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, stored)
+
+	stored, err = c.Add(t.Context(), "k", 7, time.Second)
+	require.NoError(t, err)
+	require.False(t, stored)
+
+	n, ok, err = c.Incr(t.Context(), "k", 3)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(8), n)
+
+	n, ok, err = c.Decr(t.Context(), "k", 10)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Zero(t, n)
+
+	// The ttl is rounded up to the second.
+	clock.Advance(1999 * time.Millisecond)
+	require.Equal(t, 1, s.Len())
+	clock.Advance(time.Millisecond)
+	require.Zero(t, s.Len())
+}
+
+func TestClient_LongTTL(t *testing.T) {
+	t.Parallel()
+
+	// Beyond 30 days the expiry is sent as a Unix time.
+	clock := ratetest.NewClock(time.Now())
+	s, c := newServer(t, clock)
+
+	stored, err := c.Add(t.Context(), "k", 1, 40*24*time.Hour)
+	require.NoError(t, err)
+	require.True(t, stored)
+
+	clock.Advance(39 * 24 * time.Hour)
+	require.Equal(t, 1, s.Len())
+	clock.Advance(2 * 24 * time.Hour)
+	require.Zero(t, s.Len())
+}
+
+func TestClient_InvalidKey(t *testing.T) {
+	t.Parallel()
+
+	c := memcached.NewClient("localhost:0")
+
+	for _, key := range []string{"", "a b", "a\nb", "a\x7fb", strings.Repeat("a", 251)} {
+		_, err := c.Add(t.Context(), key, 1, time.Second)
+		require.ErrorIs(t, err, memcached.ErrInvalidKey)
+
+		_, _, err = c.Incr(t.Context(), key, 1)
+		require.ErrorIs(t, err, memcached.ErrInvalidKey)
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	s := memcachedtest.NewServer()
+	t.Cleanup(s.Close)
+
+	c := memcached.NewClient(s.Addr())
+	t.Cleanup(func() { c.Close() })
+
+	_, err := c.Add(t.Context(), "n", 0, time.Hour)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	for range 50 {
+		wg.Go(func() {
+			_, _, err := c.Incr(t.Context(), "n", 1)
+			assert.NoError(t, err)
+		})
+	}
+
+	wg.Wait()
+
+	n, ok := s.Get("n")
+	require.True(t, ok)
+	require.Equal(t, "50", n)
+}
+
+func TestClient_Close(t *testing.T) {
+	t.Parallel()
+
+	_, c := newServer(t, ratetest.NewClock(start))
+
+	_, err := c.Add(t.Context(), "k", 1, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	_, _, err = c.Incr(t.Context(), "k", 1)
+	require.ErrorIs(t, err, memcached.ErrClosed)
+}
+
+func TestClient_Dial(t *testing.T) {
+	t.Parallel()
+
+	s := memcachedtest.NewServer()
+	s.Close()
+
+	_, _, err := memcached.NewClient(s.Addr()).Incr(t.Context(), "k", 1)
+	require.Error(t, err)
+}
+
+// serve accepts a single connection, reads a command and answers it with
+// reply before hanging up. An empty reply never answers.
+func serve(t *testing.T, reply string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply == "" {
+			<-t.Context().Done()
+
+			return
+		}
+
+		conn.Write([]byte(reply))
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestClient_Canceled(t *testing.T) {
+	t.Parallel()
+
+	c := memcached.NewClient(serve(t, ""))
+	t.Cleanup(func() { c.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err := c.Incr(ctx, "k", 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	t.Parallel()
+
+	// The server never answers and the context has no deadline.
+	c := memcached.NewClient(serve(t, ""), memcached.WithTimeout(10*time.Millisecond))
+	t.Cleanup(func() { c.Close() })
+
+	_, _, err := c.Incr(context.Background(), "k", 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Disabled, the context's deadline still applies.
+	c = memcached.NewClient(serve(t, ""), memcached.WithTimeout(-1))
+	t.Cleanup(func() { c.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Add(ctx, "k", 1, time.Second)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_ErrorReplies(t *testing.T) {
+	t.Parallel()
+
+	for _, reply := range []string{"ERROR", "CLIENT_ERROR bad data chunk", "SERVER_ERROR out of memory"} {
+		c := memcached.NewClient(serve(t, reply+"\r\n"))
+
+		_, _, err := c.Incr(t.Context(), "k", 1)
+		require.Equal(t, memcached.Error(reply), err)
+
+		_, err = c.Add(t.Context(), "k", 1, time.Second)
+		require.Error(t, err) // The server hung up.
+
+		c.Close()
+	}
+}
+
+func TestClient_MalformedReply(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(c *memcached.Client) error{
+		"incr": func(c *memcached.Client) error {
+			_, _, err := c.Incr(t.Context(), "k", 1)
+
+			return err
+		},
+		"add": func(c *memcached.Client) error {
+			_, err := c.Add(t.Context(), "k", 1, time.Second)
+
+			return err
+		},
+	}
+
+	for name, call := range tests {
+		for _, reply := range []string{"OK\n", "EXISTS\r\n", "-1\r\n", "\r"} {
+			c := memcached.NewClient(serve(t, reply))
+			require.Error(t, call(c), "%s %q", name, reply)
+			c.Close()
+		}
+	}
+}
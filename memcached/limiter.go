@@ -0,0 +1,148 @@
+// Package memcached implements the fixed window algorithm of the window
+// package on a memcached server, so that several instances of a service can
+// share limits. Each window is counted in its own item, named after the key
+// and the window's start and expiring when the window ends, with add and
+// incr, which memcached applies atomically.
+//
+// Windows are computed from the instances' clocks, which must roughly agree.
+// The memcachedtest package provides an in-process server for tests.
+package memcached
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/store"
+	"github.com/serroba/rate/window"
+)
+
+// FixedLimiter is a fixed window limiter with the semantics of
+// window.FixedLimiter whose count lives in memcached.
+//
+// Requests are counted optimistically: they are added to the window's count
+// and taken back if the count goes over the limit. So while requests race for
+// the last few slots of a window one of them may be denied even though it
+// would have fit, but the limit is never exceeded.
+type FixedLimiter struct {
+	store.Decider
+
+	counters Counters
+	key      string
+	limit    uint32
+	window   time.Duration
+	clock    clock.Clock
+}
+
+// NewFixedLimiter creates a fixed window limiter that counts requests under
+// key. Limit is the maximum requests per window. Window is the duration of
+// each window, aligned to the Unix epoch.
+func NewFixedLimiter(c Counters, key string, limit uint32, window time.Duration) *FixedLimiter {
+	return NewFixedLimiterWithClock(c, key, limit, window, clock.Real{})
+}
+
+// NewFixedLimiterWithClock creates a fixed window limiter with a custom clock.
+// Use this constructor for testing with a mock clock.
+func NewFixedLimiterWithClock(
+	c Counters, key string, limit uint32, window time.Duration, clock clock.Clock,
+) *FixedLimiter {
+	if window == 0 {
+		window = 1 * time.Second
+	}
+
+	l := &FixedLimiter{counters: c, key: key, limit: limit, window: window, clock: clock}
+	l.Decider = store.NewDecider(l.DecideNContext)
+
+	return l
+}
+
+// DecideNContext reports whether n requests fit into the current window at
+// once, and the window state. It passes ctx to the client and returns its
+// error, if any.
+func (l *FixedLimiter) DecideNContext(ctx context.Context, n uint32) (rate.Decision, error) {
+	now := l.clock.Now()
+	start := window.Start(now, l.window)
+	end := start.Add(l.window)
+	key := l.item(start)
+
+	d := rate.Decision{Limit: l.limit, ResetAt: end}
+
+	// Too large to ever fit: look at the count without changing it.
+	delta := uint64(n)
+	if n > l.limit {
+		delta = 0
+	}
+
+	count, err := l.incr(ctx, key, delta, end.Sub(now))
+	if err != nil {
+		return rate.Decision{}, err
+	}
+
+	switch {
+	case n > l.limit:
+		// Never allowed, as window.ErrExceedsLimit.
+	case count <= uint64(l.limit):
+		d.Allowed = true
+	default:
+		if count, _, err = l.counters.Decr(ctx, key, delta); err != nil {
+			return rate.Decision{}, err
+		}
+
+		d.RetryAfter = end.Sub(now)
+	}
+
+	if count < uint64(l.limit) {
+		d.Remaining = l.limit - uint32(count)
+	}
+
+	return d, nil
+}
+
+// item returns the key of the item counting the window starting at start.
+func (l *FixedLimiter) item(start time.Time) string {
+	return l.key + ":" + strconv.FormatInt(start.UnixNano(), 10)
+}
+
+// incr adds delta to the count under key, creating it with the given ttl if
+// it does not exist, and returns the new count.
+func (l *FixedLimiter) incr(ctx context.Context, key string, delta uint64, ttl time.Duration) (uint64, error) {
+	for {
+		count, ok, err := l.counters.Incr(ctx, key, delta)
+		if err != nil || ok {
+			return count, err
+		}
+
+		if delta == 0 {
+			return 0, nil
+		}
+
+		stored, err := l.counters.Add(ctx, key, delta, ttl)
+		if err != nil || stored {
+			return delta, err
+		}
+
+		// Another instance created it in between.
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Reset clears the count of the current window, so the limiter starts over
+// like a newly created one, e.g. to unblock a customer. Earlier windows are
+// left to expire, as they no longer count.
+func (l *FixedLimiter) Reset(ctx context.Context) error {
+	// Decrements stop at zero.
+	_, _, err := l.counters.Decr(ctx, l.item(window.Start(l.clock.Now(), l.window)), math.MaxUint64)
+
+	return err
+}
+
+// RecoveredAt reports that a registry may drop the limiter whenever it likes:
+// the count lives in memcached, not in the limiter.
+func (l *FixedLimiter) RecoveredAt() (time.Time, bool) {
+	return time.Time{}, true
+}
@@ -0,0 +1,299 @@
+package memcached_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/memcached"
+	"github.com/serroba/rate/memcached/memcachedtest"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	start   = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	errTest = errors.New("test")
+)
+
+func newServer(t *testing.T, clock *ratetest.Clock) (*memcachedtest.Server, *memcached.Client) {
+	t.Helper()
+
+	s := memcachedtest.NewServerWithClock(clock)
+	t.Cleanup(s.Close)
+
+	c := memcached.NewClient(s.Addr())
+	t.Cleanup(func() { c.Close() })
+
+	return s, c
+}
+
+func TestFixedLimiter_MatchesAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	_, c := newServer(t, clock)
+	local := window.NewFixedLimiterWithClock(5, time.Second, clock)
+	lim := memcached.NewFixedLimiterWithClock(c, "alice", 5, time.Second, clock)
+
+	for i := range 200 {
+		if i%7 == 0 {
+			clock.Advance(time.Duration(i%5) * 100 * time.Millisecond)
+		}
+
+		n := uint32(i%3 + 1)
+		if i%11 == 0 {
+			n = 6 // Never fits
+		}
+
+		d, err := lim.DecideNContext(t.Context(), n)
+		require.NoError(t, err)
+		require.Equal(t, local.DecideN(n), d, "step %d", i)
+	}
+}
+
+func TestFixedLimiter_Keys(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	s, c := newServer(t, clock)
+	lim := memcached.NewFixedLimiterWithClock(c, "alice", 5, time.Minute, clock)
+
+	// Each window has its own item, named after its start.
+	require.True(t, lim.AllowN(2))
+
+	count, ok := s.Get("alice:1704110400000000000")
+	require.True(t, ok)
+	require.Equal(t, "2", count)
+
+	// It expires once its window has ended.
+	clock.Advance(time.Minute)
+	require.Zero(t, s.Len())
+	require.True(t, lim.AllowN(3))
+
+	count, ok = s.Get("alice:1704110460000000000")
+	require.True(t, ok)
+	require.Equal(t, "3", count)
+}
+
+func TestFixedLimiter_Reset(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	s, c := newServer(t, clock)
+	lim := memcached.NewFixedLimiterWithClock(c, "alice", 2, time.Minute, clock)
+
+	require.True(t, lim.AllowN(2))
+	require.False(t, lim.Allow())
+
+	require.NoError(t, lim.Reset(t.Context()))
+	require.True(t, lim.AllowN(2))
+
+	// Resetting a window nobody counted in yet is fine too.
+	clock.Advance(time.Minute)
+	require.NoError(t, lim.Reset(t.Context()))
+	require.True(t, lim.AllowN(2))
+
+	s.Close()
+	require.Error(t, lim.Reset(t.Context()))
+}
+
+func TestFixedLimiter_DefaultWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	_, c := newServer(t, clock)
+	lim := memcached.NewFixedLimiterWithClock(c, "alice", 1, 0, clock)
+
+	require.True(t, lim.Allow())
+	require.Equal(t, rate.Decision{
+		Limit:      1,
+		ResetAt:    start.Add(time.Second),
+		RetryAfter: time.Second,
+	}, lim.Decide())
+}
+
+func TestFixedLimiter_SharedState(t *testing.T) {
+	t.Parallel()
+
+	s := memcachedtest.NewServer()
+	t.Cleanup(s.Close)
+
+	// Two instances share the limit.
+	instances := make([]*memcached.FixedLimiter, 2)
+	for i := range instances {
+		c := memcached.NewClient(s.Addr())
+		t.Cleanup(func() { c.Close() })
+
+		instances[i] = memcached.NewFixedLimiter(c, "alice", 100, time.Hour)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int32
+	)
+
+	for i := range 200 {
+		wg.Go(func() {
+			if instances[i%2].Allow() {
+				allowed.Add(1)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	// Racing requests may be denied for each other, but never go over.
+	require.LessOrEqual(t, allowed.Load(), int32(100))
+	require.Positive(t, allowed.Load())
+}
+
+// counters wraps Counters, letting tests fail or interleave calls.
+type counters struct {
+	memcached.Counters
+
+	add  func() error
+	incr func() error
+	decr func() error
+}
+
+func (c *counters) Add(ctx context.Context, key string, value uint64, ttl time.Duration) (bool, error) {
+	if c.add != nil {
+		if err := c.add(); err != nil {
+			return false, err
+		}
+	}
+
+	return c.Counters.Add(ctx, key, value, ttl)
+}
+
+func (c *counters) Incr(ctx context.Context, key string, delta uint64) (uint64, bool, error) {
+	if c.incr != nil {
+		if err := c.incr(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return c.Counters.Incr(ctx, key, delta)
+}
+
+func (c *counters) Decr(ctx context.Context, key string, delta uint64) (uint64, bool, error) {
+	if c.decr != nil {
+		if err := c.decr(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return c.Counters.Decr(ctx, key, delta)
+}
+
+func TestFixedLimiter_AddRace(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(start)
+	_, c := newServer(t, clock)
+	other := memcached.NewFixedLimiterWithClock(c, "alice", 5, time.Second, clock)
+
+	// Another instance creates the item between our incr and add.
+	raced := false
+	wrapped := &counters{Counters: c, add: func() error {
+		if !raced {
+			raced = true
+
+			require.True(t, other.AllowN(2))
+		}
+
+		return nil
+	}}
+
+	lim := memcached.NewFixedLimiterWithClock(wrapped, "alice", 5, time.Second, clock)
+	d, err := lim.DecideNContext(t.Context(), 3)
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+	require.Zero(t, d.Remaining)
+}
+
+func TestFixedLimiter_Errors(t *testing.T) {
+	t.Parallel()
+
+	fail := func() error { return errTest }
+
+	tests := map[string]*counters{
+		"incr": {incr: fail},
+		"add":  {add: fail},
+		"decr": {decr: fail},
+	}
+
+	for name, wrapped := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratetest.NewClock(start)
+			_, c := newServer(t, clock)
+			wrapped.Counters = c
+
+			// Fill the window so the next request is taken back.
+			full := memcached.NewFixedLimiterWithClock(c, "alice", 1, time.Second, clock)
+			lim := memcached.NewFixedLimiterWithClock(wrapped, "alice", 1, time.Second, clock)
+
+			if name != "add" {
+				require.True(t, full.Allow())
+			}
+
+			_, err := lim.DecideNContext(t.Context(), 1)
+			require.ErrorIs(t, err, errTest)
+
+			// Failures deny.
+			require.False(t, lim.Allow())
+			require.Equal(t, rate.Decision{}, lim.Decide())
+		})
+	}
+}
+
+// vanishing is an item that expires between every incr and add.
+type vanishing struct {
+	adds int
+}
+
+func (v *vanishing) Add(context.Context, string, uint64, time.Duration) (bool, error) {
+	v.adds++
+
+	return false, nil
+}
+
+func (v *vanishing) Incr(context.Context, string, uint64) (uint64, bool, error) {
+	return 0, false, nil
+}
+
+func (v *vanishing) Decr(context.Context, string, uint64) (uint64, bool, error) {
+	return 0, false, nil
+}
+
+func TestFixedLimiter_Canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	// It keeps trying until ctx is done.
+	v := &vanishing{}
+	_, err := memcached.NewFixedLimiter(v, "alice", 1, time.Second).DecideNContext(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Greater(t, v.adds, 1)
+}
+
+func TestFixedLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	lim := memcached.NewFixedLimiter(memcached.NewClient("localhost:0"), "alice", 1, time.Second)
+
+	// The count lives in memcached, so the limiter can always be dropped.
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.True(t, at.IsZero())
+}
@@ -0,0 +1,316 @@
+// Package memcachedtest provides an in-process memcached server for testing
+// code that uses the memcached package.
+package memcachedtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// relativeExpiry is the longest expiry memcached takes as relative seconds;
+// longer ones are Unix times.
+const relativeExpiry = 30 * 24 * 60 * 60
+
+// Server is a memcached server speaking enough of the text protocol for the
+// memcached package: get, set, add, incr, decr and delete. Items expire by
+// its clock, so tests can expire them by advancing a fake clock.
+type Server struct {
+	ln    net.Listener
+	clock clock.Clock
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	items  map[string]item
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+type item struct {
+	value   string
+	expires time.Time // Zero if never.
+}
+
+// NewServer starts a server on a random local port. It panics if it cannot
+// listen, like httptest.NewServer. Close it when done.
+func NewServer() *Server {
+	return NewServerWithClock(clock.Real{})
+}
+
+// NewServerWithClock starts a server whose items expire by clock.
+func NewServerWithClock(clock clock.Clock) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("memcachedtest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		ln:    ln,
+		clock: clock,
+		items: make(map[string]item),
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Go(s.serve)
+
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() {
+	s.ln.Close()
+
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Get returns the value stored under key, if it has not expired.
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.get(key)
+
+	return it.value, ok
+}
+
+// Len returns the number of items that have not expired.
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+
+	for key := range s.items {
+		if _, ok := s.get(key); ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+
+			return
+		}
+
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Go(func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+
+				c.Close()
+			}()
+
+			s.handle(bufio.NewReader(c), c)
+		})
+	}
+}
+
+func (s *Server) handle(r *bufio.Reader, w io.Writer) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			fmt.Fprint(w, "ERROR\r\n")
+
+			continue
+		}
+
+		var reply string
+
+		switch fields[0] {
+		case "get":
+			reply = s.getCmd(fields[1:])
+		case "set", "add":
+			reply, err = s.storeCmd(r, fields)
+		case "incr", "decr":
+			reply = s.arithCmd(fields)
+		case "delete":
+			reply = s.deleteCmd(fields[1:])
+		default:
+			reply = "ERROR\r\n"
+		}
+
+		if err != nil {
+			return
+		}
+
+		fmt.Fprint(w, reply)
+	}
+}
+
+func (s *Server) getCmd(keys []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+
+	for _, key := range keys {
+		if it, ok := s.get(key); ok {
+			fmt.Fprintf(&b, "VALUE %s 0 %d\r\n%s\r\n", key, len(it.value), it.value)
+		}
+	}
+
+	b.WriteString("END\r\n")
+
+	return b.String()
+}
+
+// storeCmd handles "set|add <key> <flags> <exptime> <bytes>" and reads the
+// data block that follows.
+func (s *Server) storeCmd(r *bufio.Reader, fields []string) (string, error) {
+	if len(fields) != 5 {
+		return "CLIENT_ERROR bad command line format\r\n", nil
+	}
+
+	exptime, err1 := strconv.ParseInt(fields[3], 10, 64)
+	size, err2 := strconv.Atoi(fields[4])
+
+	if err1 != nil || err2 != nil || size < 0 {
+		return "CLIENT_ERROR bad command line format\r\n", nil
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+
+	if string(data[size:]) != "\r\n" {
+		return "CLIENT_ERROR bad data chunk\r\n", nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fields[1]
+	if _, ok := s.get(key); ok && fields[0] == "add" {
+		return "NOT_STORED\r\n", nil
+	}
+
+	s.items[key] = item{value: string(data[:size]), expires: s.expiry(exptime)}
+
+	return "STORED\r\n", nil
+}
+
+// arithCmd handles "incr|decr <key> <delta>". Increments wrap around at 64
+// bits and decrements stop at zero, as in memcached.
+func (s *Server) arithCmd(fields []string) string {
+	if len(fields) != 3 {
+		return "ERROR\r\n"
+	}
+
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument\r\n"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.get(fields[1])
+	if !ok {
+		return "NOT_FOUND\r\n"
+	}
+
+	n, err := strconv.ParseUint(it.value, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	}
+
+	if fields[0] == "incr" {
+		n += delta
+	} else {
+		n -= min(n, delta)
+	}
+
+	it.value = strconv.FormatUint(n, 10)
+	s.items[fields[1]] = it
+
+	return it.value + "\r\n"
+}
+
+func (s *Server) deleteCmd(args []string) string {
+	if len(args) != 1 {
+		return "ERROR\r\n"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.get(args[0]); !ok {
+		return "NOT_FOUND\r\n"
+	}
+
+	delete(s.items, args[0])
+
+	return "DELETED\r\n"
+}
+
+// get returns key's item, dropping it if it has expired. The caller must hold
+// s.mu.
+func (s *Server) get(key string) (item, bool) {
+	it, ok := s.items[key]
+	if ok && !it.expires.IsZero() && !s.clock.Now().Before(it.expires) {
+		delete(s.items, key)
+
+		return item{}, false
+	}
+
+	return it, ok
+}
+
+// expiry converts an exptime to a time: zero never expires, up to 30 days is
+// relative seconds, larger values are Unix times and negative values have
+// already expired.
+func (s *Server) expiry(exptime int64) time.Time {
+	now := s.clock.Now()
+
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return now
+	case exptime <= relativeExpiry:
+		return now.Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
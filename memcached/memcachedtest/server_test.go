@@ -0,0 +1,133 @@
+package memcachedtest_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/memcached/memcachedtest"
+	"github.com/serroba/rate/ratetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Protocol(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := memcachedtest.NewServerWithClock(clock)
+	t.Cleanup(s.Close)
+
+	conn, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		cmd, reply string
+	}{
+		{"get k\r\n", "END\r\n"},
+		{"set k 0 0 2\r\n10\r\n", "STORED\r\n"},
+		{"add k 0 0 1\r\n5\r\n", "NOT_STORED\r\n"},
+		{"get k missing\r\n", "VALUE k 0 2\r\n10\r\nEND\r\n"},
+		{"incr k 5\r\n", "15\r\n"},
+		{"decr k 20\r\n", "0\r\n"},
+		{"incr missing 1\r\n", "NOT_FOUND\r\n"},
+		{"incr k x\r\n", "CLIENT_ERROR invalid numeric delta argument\r\n"},
+		{"incr k\r\n", "ERROR\r\n"},
+		{"set s 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{"incr s 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"delete s\r\n", "DELETED\r\n"},
+		{"delete s\r\n", "NOT_FOUND\r\n"},
+		{"delete\r\n", "ERROR\r\n"},
+		{"set k 0 x 1\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"set k 0 0\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"\r\n", "ERROR\r\n"},
+		{"version\r\n", "ERROR\r\n"},
+		{"add e 0 -1 1\r\n1\r\n", "STORED\r\n"},
+		{"get e\r\n", "END\r\n"},
+		{"set k 0 0 1\r\nxyz\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+	}
+
+	for _, tt := range tests {
+		_, err := io.WriteString(conn, tt.cmd)
+		require.NoError(t, err)
+
+		reply := make([]byte, len(tt.reply))
+		_, err = io.ReadFull(r, reply)
+		require.NoError(t, err)
+		require.Equal(t, tt.reply, string(reply), tt.cmd)
+	}
+
+	// The rest of the bad data chunk was read as a command.
+	require.Equal(t, "ERROR\r\n", readLine(t, r))
+	require.Equal(t, 1, s.Len())
+}
+
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	return line
+}
+
+func TestServer_Expiry(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := memcachedtest.NewServerWithClock(clock)
+	t.Cleanup(s.Close)
+
+	conn, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	r := bufio.NewReader(conn)
+
+	for _, cmd := range []string{
+		"set relative 0 10 1\r\n1\r\n",
+		"set absolute 0 1704110430 1\r\n1\r\n",
+		"set never 0 0 1\r\n1\r\n",
+	} {
+		_, err := io.WriteString(conn, cmd)
+		require.NoError(t, err)
+		require.Equal(t, "STORED\r\n", readLine(t, r))
+	}
+
+	require.Equal(t, 3, s.Len())
+
+	clock.Advance(10 * time.Second)
+
+	_, ok := s.Get("relative")
+	require.False(t, ok)
+
+	clock.Advance(20 * time.Second)
+
+	_, ok = s.Get("absolute")
+	require.False(t, ok)
+
+	v, ok := s.Get("never")
+	require.True(t, ok)
+	require.Equal(t, "1", v)
+}
+
+func TestServer_Close(t *testing.T) {
+	t.Parallel()
+
+	s := memcachedtest.NewServer()
+
+	conn, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	// Open connections are closed too.
+	s.Close()
+
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serroba/rate"
+)
+
+// setHeaders sets the rate limit headers chosen by c from d. Decisions that
+// carry no limiter state, such as those of limiters that do not implement
+// registry.Decider or made by a failure policy, set none.
+func (c *config) setHeaders(h http.Header, d rate.Decision) {
+	if (c.policy == "" && !c.legacy) || (d.Limit == 0 && d.ResetAt.IsZero()) {
+		return
+	}
+
+	now := c.clock.Now()
+	limit := strconv.FormatUint(uint64(d.Limit), 10)
+	remaining := strconv.FormatUint(uint64(d.Remaining), 10)
+
+	if c.policy != "" {
+		name := quote(c.policy)
+		value := name + ";r=" + remaining
+
+		if !d.ResetAt.IsZero() {
+			value += ";t=" + strconv.FormatInt(ceilSeconds(d.ResetAt.Sub(now)), 10)
+		}
+
+		h.Set("RateLimit-Policy", name+";q="+limit)
+		h.Set("RateLimit", value)
+	}
+
+	if c.legacy {
+		h.Set("X-RateLimit-Limit", limit)
+		h.Set("X-RateLimit-Remaining", remaining)
+
+		if !d.ResetAt.IsZero() {
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(ceilUnix(d.ResetAt, now), 10))
+		}
+	}
+}
+
+// ceilSeconds returns d in whole seconds, rounded up, or zero if d is
+// negative.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(max(0, math.Ceil(d.Seconds())))
+}
+
+// ceilUnix returns t, or now if t is earlier, as a Unix time in seconds,
+// rounded up.
+func ceilUnix(t, now time.Time) int64 {
+	t = maxTime(t, now)
+	if t.Nanosecond() > 0 {
+		return t.Unix() + 1
+	}
+
+	return t.Unix()
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// quote encodes s as a structured field string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
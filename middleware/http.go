@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/serroba/rate/clock"
 	"github.com/serroba/rate/registry"
 )
 
@@ -50,24 +54,86 @@ func HeaderKeyFunc(header string) KeyFunc {
 
 // RateLimiter returns HTTP middleware that rate limits requests.
 // It uses the provided registry to track rate limits per key extracted by keyFunc.
-// Requests that exceed the rate limit receive a 429 Too Many Requests response.
-func RateLimiter(reg *registry.Registry, keyFunc KeyFunc) func(http.Handler) http.Handler {
+// Requests that exceed the rate limit receive a 429 Too Many Requests response
+// with a Retry-After header taken from the limiter's decision: the time until
+// the tokens are refilled, the window resets or the TAT allows the request,
+// rounded up to whole seconds and, with WithRetryJitter, spread out.
+//
+// Requests whose limiter fails to decide, e.g. because its store is down, are
+// handled by the registry's failure policy: with FailClosed they receive a
+// 503 Service Unavailable response, with FailOpen they pass through and with
+// FailLocal the fallback limiter decides as above. Failures are counted in the
+// registry's Stats.
+//
+// A registry created with WithGlobal also limits all requests together, e.g.
+// to protect a shared database: requests over the global limit receive a 429
+// response as well, and requests denied for their key do not count against it.
+//
+// Options add rate limit headers to every response, taken from the state of
+// the limiter that decided; see WithRateLimitHeaders and WithLegacyHeaders.
+func RateLimiter(reg *registry.Registry, keyFunc KeyFunc, opts ...Option) func(http.Handler) http.Handler {
 	if keyFunc == nil {
 		keyFunc = IPKeyFunc
 	}
 
+	cfg := config{clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := keyFunc(r)
+			d, err := reg.DecideContext(r.Context(), keyFunc(r))
+
+			if err != nil && !d.Allowed && reg.FailurePolicy() == registry.FailClosed {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+
+				return
+			}
+
+			cfg.setHeaders(w.Header(), d)
+
+			if !d.Allowed {
+				w.Header().Set("Retry-After", retryAfter(cfg.retryAfter(d.RetryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-			if !reg.Allow(key) {
-				w.Header().Set("Retry-After", "1")
+// ConcurrencyLimiter returns HTTP middleware that caps the number of requests
+// in flight per key extracted by keyFunc. The registry's limiters should cap
+// concurrency, e.g. concurrency.Limiter. A slot is taken before the handler
+// runs and released when it returns, including when it panics. Requests that
+// find no free slot receive a 429 Too Many Requests response.
+func ConcurrencyLimiter(reg *registry.Registry, keyFunc KeyFunc) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = IPKeyFunc
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			release, ok := reg.TryAcquire(keyFunc(r))
+			if !ok {
 				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 
 				return
 			}
 
+			defer release()
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// retryAfter formats d as whole seconds for the Retry-After header, rounding
+// up so clients don't retry too early. Limiters that can't tell when the
+// request would be allowed report zero, which is sent as one second.
+func retryAfter(d time.Duration) string {
+	return strconv.FormatFloat(max(1, math.Ceil(d.Seconds())), 'f', 0, 64)
+}
@@ -1,15 +1,23 @@
 package middleware_test
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/concurrency"
 	"github.com/serroba/rate/middleware"
+	"github.com/serroba/rate/ratetest"
 	"github.com/serroba/rate/registry"
+	"github.com/serroba/rate/store"
+	"github.com/serroba/rate/window"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -154,6 +162,36 @@ func TestRateLimiter_Blocks(t *testing.T) {
 	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
 }
 
+func TestRateLimiter_RetryAfterFromLimiter(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return window.NewFixedLimiter(1, time.Hour)
+	})
+	require.NoError(t, err)
+
+	handler := middleware.RateLimiter(reg, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = testRemoteAddr
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Denied until the hourly window resets
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Positive(t, retry)
+	assert.LessOrEqual(t, retry, 3600)
+}
+
 func TestRateLimiter_IndependentKeys(t *testing.T) {
 	t.Parallel()
 
@@ -259,3 +297,415 @@ func TestRateLimiter_Concurrent(t *testing.T) {
 
 	assert.Equal(t, int64(100), allowed.Load())
 }
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	})
+	require.NoError(t, err)
+
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+
+	handler := middleware.ConcurrencyLimiter(reg, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-unblock
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	slow := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	slow.RemoteAddr = testRemoteAddr
+
+	done := make(chan int)
+
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, slow)
+		done <- rec.Code
+	}()
+
+	<-entered
+
+	// The slot is held by the slow request
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = testRemoteAddr
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// Another client is unaffected
+	other := httptest.NewRequest(http.MethodGet, "/", nil)
+	other.RemoteAddr = "10.0.0.2:12345"
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, other)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Released once the slow request completes
+	close(unblock)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestConcurrencyLimiter_ReleasesOnPanic(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	})
+	require.NoError(t, err)
+
+	handler := middleware.ConcurrencyLimiter(reg, middleware.HeaderKeyFunc("X-Api-Key"))(
+		http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			panic("boom")
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Api-Key", "key-1")
+
+	for range 3 {
+		assert.Panics(t, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+
+	release, ok := reg.TryAcquire("key-1")
+	require.True(t, ok, "slot must be released after a panic")
+	release()
+}
+
+// downStore is a store that always fails.
+type downStore struct{}
+
+func (downStore) Update(context.Context, string, func([]byte) ([]byte, time.Time, error)) error {
+	return errors.New("store down")
+}
+
+func TestRateLimiter_StoreFailure(t *testing.T) {
+	t.Parallel()
+
+	algorithm := func() store.Algorithm { return bucket.NewTokenLimiter(10, 0) }
+	fallback := registry.WithFallback(func() registry.Limiter { return bucket.NewTokenLimiter(1, 0) })
+
+	tests := []struct {
+		name   string
+		opts   []registry.Option
+		status []int
+	}{
+		{
+			name:   "fail closed",
+			status: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		},
+		{
+			name:   "fail open",
+			opts:   []registry.Option{registry.WithFailurePolicy(registry.FailOpen)},
+			status: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:   "fail local",
+			opts:   []registry.Option{registry.WithFailurePolicy(registry.FailLocal), fallback},
+			status: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := registry.NewWithStore(downStore{}, algorithm, tt.opts...)
+			require.NoError(t, err)
+
+			handler := middleware.RateLimiter(reg, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = testRemoteAddr
+
+			for _, status := range tt.status {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				assert.Equal(t, status, rec.Code)
+			}
+
+			assert.Equal(t, uint64(len(tt.status)), reg.Stats().Failures)
+		})
+	}
+}
+
+func TestRateLimiter_Global(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(2, 0)
+	}, registry.WithGlobal(window.NewFixedLimiter(3, time.Hour)))
+	require.NoError(t, err)
+
+	handler := middleware.RateLimiter(reg, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// Denied for the key, without using up the global limit
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1").Code)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1").Code)
+
+	// Denied globally
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1").Code)
+
+	rec := serve("10.0.0.3:1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Positive(t, retry)
+}
+
+func TestRateLimiter_Headers(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC))
+
+	reg, err := registry.New(func() registry.Limiter {
+		return window.NewFixedLimiterWithClock(2, time.Minute, clock)
+	})
+	require.NoError(t, err)
+
+	handler := middleware.RateLimiter(reg, nil,
+		middleware.WithRateLimitHeaders(""),
+		middleware.WithLegacyHeaders(),
+		middleware.WithClock(clock),
+	)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = testRemoteAddr
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"default";q=2`, rec.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, `"default";r=1;t=50`, rec.Header().Get("RateLimit"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1704110460", rec.Header().Get("X-RateLimit-Reset"))
+
+	clock.Advance(20 * time.Second)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Denials carry the headers too
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, `"default";r=0;t=30`, rec.Header().Get("RateLimit"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1704110460", rec.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestRateLimiter_Headers_FromBucket(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC))
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewLimiterWithClock(10, 4, clock)
+	})
+	require.NoError(t, err)
+
+	handler := middleware.RateLimiter(reg, nil,
+		middleware.WithRateLimitHeaders(`api "v1"`),
+		middleware.WithLegacyHeaders(),
+		middleware.WithClock(clock),
+	)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = testRemoteAddr
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The bucket is full again 250ms from now, which is rounded up
+	assert.Equal(t, `"api \"v1\"";q=10`, rec.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, `"api \"v1\"";r=9;t=1`, rec.Header().Get("RateLimit"))
+	assert.Equal(t, "1704110411", rec.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestRateLimiter_Headers_WithoutState(t *testing.T) {
+	t.Parallel()
+
+	headers := []middleware.Option{middleware.WithRateLimitHeaders("default"), middleware.WithLegacyHeaders()}
+
+	tests := []struct {
+		name string
+		reg  func() (*registry.Registry, error)
+		opts []middleware.Option
+	}{
+		{
+			name: "not opted in",
+			reg: func() (*registry.Registry, error) {
+				return registry.New(func() registry.Limiter { return bucket.NewTokenLimiter(10, 0) })
+			},
+		},
+		{
+			name: "limiter without decisions",
+			opts: headers,
+			reg: func() (*registry.Registry, error) {
+				return registry.New(func() registry.Limiter { return concurrency.NewLimiter(1) })
+			},
+		},
+		{
+			name: "failure policy",
+			opts: headers,
+			reg: func() (*registry.Registry, error) {
+				return registry.NewWithStore(downStore{}, func() store.Algorithm {
+					return bucket.NewTokenLimiter(10, 0)
+				}, registry.WithFailurePolicy(registry.FailOpen))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := tt.reg()
+			require.NoError(t, err)
+
+			ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := middleware.RateLimiter(reg, nil, tt.opts...)(ok)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = testRemoteAddr
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			for _, h := range []string{"RateLimit-Policy", "RateLimit", "X-RateLimit-Limit", "X-RateLimit-Reset"} {
+				assert.Empty(t, rec.Header().Get(h), h)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC))
+
+	tests := []struct {
+		name    string
+		limiter func() registry.Limiter
+		want    string
+	}{
+		{
+			name:    "window reset",
+			limiter: func() registry.Limiter { return window.NewFixedLimiterWithClock(1, time.Minute, clock) },
+			want:    "50",
+		},
+		{
+			name:    "token deficit",
+			limiter: func() registry.Limiter { return bucket.NewLimiterWithClock(1, 1, clock) },
+			want:    "1",
+		},
+		{
+			name:    "emission interval",
+			limiter: func() registry.Limiter { return bucket.NewGCRALimiterWithClock(0.1, 1, clock) },
+			want:    "10",
+		},
+		{
+			name:    "leaky drain",
+			limiter: func() registry.Limiter { return bucket.NewLeakyLimiterWithClock(1, 1, clock) },
+			want:    "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := registry.New(tt.limiter)
+			require.NoError(t, err)
+
+			handler := middleware.RateLimiter(reg, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = testRemoteAddr
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusTooManyRequests, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestRateLimiter_RetryJitter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC))
+
+	reg, err := registry.New(func() registry.Limiter {
+		return window.NewFixedLimiterWithClock(1, time.Minute, clock)
+	})
+	require.NoError(t, err)
+
+	handler := middleware.RateLimiter(reg, nil, middleware.WithRetryJitter(10*time.Second))(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = testRemoteAddr
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	seen := make(map[int]bool)
+
+	for range 100 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+		retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, retry, 50)
+		require.LessOrEqual(t, retry, 60)
+
+		seen[retry] = true
+	}
+
+	// Clients are spread out
+	require.Greater(t, len(seen), 1)
+}
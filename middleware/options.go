@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// Option configures the middleware returned by RateLimiter.
+type Option func(*config)
+
+type config struct {
+	clock  clock.Clock
+	policy string // Of the IETF headers, which are sent if it is set.
+	legacy bool
+	jitter time.Duration
+}
+
+// WithRateLimitHeaders makes RateLimiter send the RateLimit-Policy and
+// RateLimit headers of the IETF httpapi draft on every response, describing
+// the key's limiter as a policy with the given name, e.g.
+//
+//	RateLimit-Policy: "default";q=100
+//	RateLimit: "default";r=42;t=30
+//
+// q is the limit, r how many requests remain and t the seconds until the
+// limiter has fully recovered. The name should be printable ASCII and
+// defaults to "default".
+func WithRateLimitHeaders(policy string) Option {
+	return func(c *config) {
+		if policy == "" {
+			policy = "default"
+		}
+
+		c.policy = policy
+	}
+}
+
+// WithLegacyHeaders makes RateLimiter send the X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers on every response.
+// X-RateLimit-Reset is the Unix time in seconds at which the limiter will
+// have fully recovered, as in GitHub's API.
+func WithLegacyHeaders() Option {
+	return func(c *config) {
+		c.legacy = true
+	}
+}
+
+// WithRetryJitter adds a random delay of up to maxJitter to the Retry-After
+// header of denied requests, so that clients denied at the same time don't all
+// retry at the same moment. The delay is only ever added, so clients are never
+// told to retry before the limiter would allow them.
+func WithRetryJitter(maxJitter time.Duration) Option {
+	return func(c *config) {
+		c.jitter = max(0, maxJitter)
+	}
+}
+
+// WithClock sets the clock the rate limit headers are computed against. It
+// defaults to the system clock.
+func WithClock(clk clock.Clock) Option {
+	return func(c *config) {
+		if clk != nil {
+			c.clock = clk
+		}
+	}
+}
+
+// retryAfter returns how long a client denied by d should wait, with jitter.
+func (c *config) retryAfter(d time.Duration) time.Duration {
+	if c.jitter == 0 {
+		return d
+	}
+
+	return d + rand.N(c.jitter+1) //nolint:gosec // Jitter needs no secure randomness
+}
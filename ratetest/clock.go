@@ -0,0 +1,140 @@
+// Package ratetest provides helpers for testing code that uses the limiters in
+// this module.
+package ratetest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// Clock is a fake clock.TimerClock whose time only moves when told to.
+// Timers created with NewTimer fire when Advance or Set moves time to or past
+// their deadline, which unblocks limiters sleeping in Wait deterministically.
+// It is safe for concurrent use.
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*Timer
+}
+
+// NewClock returns a fake clock set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// Now returns the fake current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d and fires every timer that is due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to t, which may be in the past, and fires every timer
+// that is due.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(t)
+}
+
+func (c *Clock) set(t time.Time) {
+	c.now = t
+
+	slices.SortStableFunc(c.timers, func(a, b *Timer) int {
+		return a.deadline.Compare(b.deadline)
+	})
+
+	i := 0
+	for ; i < len(c.timers) && !c.timers[i].deadline.After(t); i++ {
+		c.timers[i].ch <- t
+	}
+
+	c.timers = slices.Delete(c.timers, 0, i)
+	c.cond.Broadcast()
+}
+
+// NewTimer returns a timer that fires once the clock reaches Now() + d.
+// A timer with d <= 0 fires immediately.
+func (c *Clock) NewTimer(d time.Duration) clock.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &Timer{clock: c, deadline: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+
+		return t
+	}
+
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+
+	return t
+}
+
+// Timers returns the number of timers that have not fired or been stopped.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are pending. Tests use it to wait
+// for goroutines to start sleeping in Wait before advancing the clock.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Timer is a timer created by Clock.NewTimer.
+type Timer struct {
+	clock    *Clock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// C returns the channel on which the fire time is delivered.
+func (t *Timer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop prevents the timer from firing. It returns false if the timer has
+// already fired or been stopped.
+func (t *Timer) Stop() bool {
+	c := t.clock
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+
+	c.timers = slices.Delete(c.timers, i, i+1)
+	c.cond.Broadcast()
+
+	return true
+}
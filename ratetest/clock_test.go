@@ -0,0 +1,86 @@
+package ratetest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/ratetest"
+	"github.com/stretchr/testify/require"
+)
+
+var _ clock.TimerClock = (*ratetest.Clock)(nil)
+
+func TestClock_AdvanceAndSet(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := ratetest.NewClock(start)
+	require.Equal(t, start, c.Now())
+
+	c.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), c.Now())
+
+	c.Set(start.Add(-time.Hour))
+	require.Equal(t, start.Add(-time.Hour), c.Now())
+}
+
+func TestClock_TimersFireInOrder(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := ratetest.NewClock(start)
+
+	late := c.NewTimer(2 * time.Second)
+	early := c.NewTimer(time.Second)
+	require.Equal(t, 2, c.Timers())
+
+	c.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), <-early.C())
+	require.Len(t, late.C(), 0)
+	require.Equal(t, 1, c.Timers())
+
+	c.Set(start.Add(time.Hour))
+	require.Equal(t, start.Add(time.Hour), <-late.C())
+	require.Zero(t, c.Timers())
+	require.False(t, late.Stop())
+}
+
+func TestClock_NewTimer_Immediate(t *testing.T) {
+	t.Parallel()
+
+	c := ratetest.NewClock(time.Now())
+
+	timer := c.NewTimer(0)
+	require.Equal(t, c.Now(), <-timer.C())
+	require.Zero(t, c.Timers())
+}
+
+func TestClock_Stop(t *testing.T) {
+	t.Parallel()
+
+	c := ratetest.NewClock(time.Now())
+
+	timer := c.NewTimer(time.Second)
+	require.True(t, timer.Stop())
+	require.False(t, timer.Stop())
+
+	c.Advance(time.Hour)
+	require.Len(t, timer.C(), 0)
+}
+
+func TestClock_BlockUntil(t *testing.T) {
+	t.Parallel()
+
+	c := ratetest.NewClock(time.Now())
+	fired := make(chan struct{})
+
+	go func() {
+		<-c.NewTimer(time.Second).C()
+		close(fired)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Second)
+	<-fired
+}
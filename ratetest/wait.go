@@ -0,0 +1,40 @@
+package ratetest
+
+import (
+	"testing"
+	"time"
+)
+
+// WaitAsync runs wait in a goroutine and delivers its result on the returned
+// channel, so a test can advance a Clock while a limiter waits on it.
+func WaitAsync(wait func() error) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- wait()
+	}()
+
+	return done
+}
+
+// RequireWokenAfter checks that the waiter sleeping on c, whose result is
+// delivered on done, wakes without error exactly after d, not a nanosecond
+// earlier. It fails t otherwise.
+func RequireWokenAfter(t testing.TB, c *Clock, done <-chan error, d time.Duration) {
+	t.Helper()
+
+	c.BlockUntil(1)
+	c.Advance(d - time.Nanosecond)
+
+	select {
+	case err := <-done:
+		t.Fatalf("woke up early: err = %v", err)
+	default:
+	}
+
+	c.Advance(time.Nanosecond)
+
+	if err := <-done; err != nil {
+		t.Fatalf("woke up with error: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package ratetest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/ratetest"
+)
+
+func TestRequireWokenAfter(t *testing.T) {
+	t.Parallel()
+
+	c := ratetest.NewClock(time.Now())
+
+	done := ratetest.WaitAsync(func() error {
+		return clock.Sleep(t.Context(), c, time.Second)
+	})
+	ratetest.RequireWokenAfter(t, c, done, time.Second)
+}
@@ -0,0 +1,201 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/serroba/rate/internal/pool"
+)
+
+// ErrClosed is returned by Client.Do after the client has been closed.
+var ErrClosed = errors.New("redis: client closed")
+
+// Error is an error reply from the server, such as "NOSCRIPT No matching script".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Doer sends a command to a Redis server and returns its reply. Replies are
+// nil, int64, string, []any or an Error. Client implements it; other clients
+// can be adapted with DoerFunc.
+type Doer interface {
+	Do(ctx context.Context, args ...string) (any, error)
+}
+
+// DoerFunc adapts a function to a Doer.
+type DoerFunc func(ctx context.Context, args ...string) (any, error)
+
+// Do calls f.
+func (f DoerFunc) Do(ctx context.Context, args ...string) (any, error) {
+	return f(ctx, args...)
+}
+
+// Client is a minimal RESP2 client that is safe for concurrent use. It keeps
+// up to 16 connections open between commands and opens more as needed.
+type Client struct {
+	pool *pool.Pool
+}
+
+// DefaultTimeout is how long a command may take, including dialing, unless
+// set otherwise with WithTimeout.
+const DefaultTimeout = pool.DefaultTimeout
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets how long a command may take, including dialing a
+// connection for it, before it is aborted with context.DeadlineExceeded. It
+// applies on top of the deadline of the command's context, if any, so a
+// stalled server cannot block callers whose context never ends. A timeout of
+// zero or less disables it.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.pool.SetTimeout(d)
+	}
+}
+
+// conn reads and writes RESP2 on a pooled connection.
+type conn struct {
+	*pool.Conn
+}
+
+// NewClient creates a client for the server at addr, such as "localhost:6379".
+// Connections are opened on first use. Commands time out after DefaultTimeout
+// unless set otherwise with WithTimeout.
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{pool: pool.New(addr, ErrClosed, isReply)}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Do sends the command made of args and returns the reply. Error replies are
+// returned as an Error. The connection is closed and the command aborted when
+// ctx is done or the client's timeout has passed.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	var reply any
+
+	err := c.pool.Do(ctx, func(pc *pool.Conn) error {
+		var err error
+
+		reply, err = conn{pc}.do(args)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// Close closes the idle connections. Commands in flight finish on their own
+// connection, which is then closed.
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
+
+// isReply reports whether err is an error reply, after which the connection
+// can be reused.
+func isReply(err error) bool {
+	var e Error
+
+	return errors.As(err, &e)
+}
+
+func (cn conn) do(args []string) (any, error) {
+	fmt.Fprintf(cn.W, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(cn.W, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if err := cn.W.Flush(); err != nil {
+		return nil, err
+	}
+
+	return cn.read()
+}
+
+// read reads a reply. Error replies are returned as an Error once the whole
+// reply has been read, so the connection can be reused.
+func (cn conn) read() (any, error) {
+	line, err := cn.R.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, Error(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		return cn.readBulk(line)
+	case '*':
+		return cn.readArray(line)
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+func (cn conn) readBulk(size string) (any, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return nil, nil //nolint:nilnil // The nil reply
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(cn.R, buf); err != nil {
+		return nil, err
+	}
+
+	return string(buf[:n]), nil
+}
+
+// readArray reads the elements of an array reply. Error replies among them
+// are kept as Error values.
+func (cn conn) readArray(size string) (any, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return nil, nil //nolint:nilnil // The nil reply
+	}
+
+	arr := make([]any, n)
+	for i := range arr {
+		arr[i], err = cn.read()
+
+		var e Error
+		if errors.As(err, &e) {
+			arr[i] = e
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return arr, nil
+}
@@ -0,0 +1,171 @@
+package redis_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/serroba/rate/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test")
+
+func TestClient_Do(t *testing.T) {
+	t.Parallel()
+
+	m := miniredis.RunT(t)
+	c := redis.NewClient(m.Addr())
+	t.Cleanup(func() { c.Close() })
+
+	tests := []struct {
+		args []string
+		want any
+		err  error
+	}{
+		{args: []string{"SET", "k", "v"}, want: "OK"},
+		{args: []string{"GET", "k"}, want: "v"},
+		{args: []string{"GET", "missing"}, want: nil},
+		{args: []string{"INCRBY", "n", "5"}, want: int64(5)},
+		{args: []string{"RPUSH", "l", "a", "b"}, want: int64(2)},
+		{args: []string{"LRANGE", "l", "0", "-1"}, want: []any{"a", "b"}},
+		{args: []string{"NOPE"}, err: redis.Error("ERR unknown command `NOPE`, with args beginning with: ")},
+		{args: []string{"EVAL", "return {1, redis.error_reply('boom')}", "0"}, want: []any{int64(1), redis.Error("ERR boom")}},
+		{args: []string{"EVAL", "return nil", "0"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		got, err := c.Do(t.Context(), tt.args...)
+		require.Equal(t, tt.err, err, tt.args)
+		require.Equal(t, tt.want, got, tt.args)
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	m := miniredis.RunT(t)
+	c := redis.NewClient(m.Addr())
+	t.Cleanup(func() { c.Close() })
+
+	var wg sync.WaitGroup
+
+	for range 50 {
+		wg.Go(func() {
+			_, err := c.Do(context.Background(), "INCR", "n")
+			assert.NoError(t, err)
+		})
+	}
+
+	wg.Wait()
+
+	n, err := c.Do(t.Context(), "GET", "n")
+	require.NoError(t, err)
+	require.Equal(t, "50", n)
+}
+
+func TestClient_Close(t *testing.T) {
+	t.Parallel()
+
+	m := miniredis.RunT(t)
+	c := redis.NewClient(m.Addr())
+
+	_, err := c.Do(t.Context(), "PING")
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	_, err = c.Do(t.Context(), "PING")
+	require.ErrorIs(t, err, redis.ErrClosed)
+}
+
+func TestClient_Dial(t *testing.T) {
+	t.Parallel()
+
+	m := miniredis.RunT(t)
+	addr := m.Addr()
+	m.Close()
+
+	_, err := redis.NewClient(addr).Do(t.Context(), "PING")
+	require.Error(t, err)
+}
+
+// serve accepts a single connection, reads a command and answers it with
+// reply before hanging up. An empty reply never answers.
+func serve(t *testing.T, reply string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply == "" {
+			<-t.Context().Done()
+
+			return
+		}
+
+		conn.Write([]byte(reply))
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestClient_Canceled(t *testing.T) {
+	t.Parallel()
+
+	c := redis.NewClient(serve(t, ""))
+	t.Cleanup(func() { c.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Do(ctx, "PING")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	t.Parallel()
+
+	// The server never answers and the context has no deadline.
+	c := redis.NewClient(serve(t, ""), redis.WithTimeout(10*time.Millisecond))
+	t.Cleanup(func() { c.Close() })
+
+	_, err := c.Do(context.Background(), "PING")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Disabled, the context's deadline still applies.
+	c = redis.NewClient(serve(t, ""), redis.WithTimeout(0))
+	t.Cleanup(func() { c.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Do(ctx, "PING")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_MalformedReply(t *testing.T) {
+	t.Parallel()
+
+	for _, reply := range []string{"?\r\n", "+OK\n", ":x\r\n", "$x\r\n", "*x\r\n", "$5\r\nab", "*2\r\n:1\r\n"} {
+		c := redis.NewClient(serve(t, reply))
+
+		_, err := c.Do(t.Context(), "PING")
+		require.Error(t, err, reply)
+
+		c.Close()
+	}
+}
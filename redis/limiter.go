@@ -0,0 +1,148 @@
+// Package redis implements the algorithms of the bucket and window packages
+// as Lua scripts run by a Redis server, so that several instances of a
+// service can share limits. Each decision is a single atomic round trip and
+// uses the server's clock, so the instances' clocks do not need to agree.
+//
+// The scripts need Redis 5 or later. Keys expire once their limiter has fully
+// recovered, so idle keys do not take up memory.
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/store"
+)
+
+// ErrUnexpectedReply is returned when the server's reply to a script is not
+// what the script returns, e.g. because the Doer mangled it.
+var ErrUnexpectedReply = errors.New("redis: unexpected reply")
+
+// Limiter is a rate limiter whose state lives in Redis under a single key.
+// Limiters of different algorithms must not share a key.
+type Limiter struct {
+	store.Decider
+
+	doer   Doer
+	key    string
+	script *script
+	args   []string
+}
+
+// NewTokenLimiter creates a token bucket limiter with the semantics of
+// bucket.TokenLimiter.
+func NewTokenLimiter(c Doer, key string, capacity, rate uint32) *Limiter {
+	return newLimiter(c, key, tokenScript, uint64(capacity), uint64(rate))
+}
+
+// NewLeakyLimiter creates a leaky bucket limiter with the semantics of
+// bucket.LeakyLimiter.
+func NewLeakyLimiter(c Doer, key string, capacity, rate uint32) *Limiter {
+	return newLimiter(c, key, leakyScript, uint64(capacity), uint64(rate))
+}
+
+// NewGCRALimiter creates a GCRA limiter with the semantics of
+// bucket.GCRALimiter. The emission interval is kept in microseconds, so rates
+// above a million requests per second are treated as a million.
+func NewGCRALimiter(c Doer, key string, rate float64, burst uint32) *Limiter {
+	if burst == 0 {
+		burst = 1
+	}
+
+	if rate <= 0 {
+		rate = 1
+	}
+
+	emission := max(1, uint64(float64(time.Second/time.Microsecond)/rate))
+
+	return newLimiter(c, key, gcraScript, emission, uint64(burst))
+}
+
+// NewFixedLimiter creates a fixed window limiter with the semantics of
+// window.FixedLimiter. Windows are aligned to the Unix epoch, as there.
+func NewFixedLimiter(c Doer, key string, limit uint32, window time.Duration) *Limiter {
+	return newLimiter(c, key, fixedScript, uint64(limit), micros(window))
+}
+
+// NewSlidingLimiter creates a sliding window log limiter with the semantics
+// of window.SlidingLimiter, keeping the log in a sorted set.
+func NewSlidingLimiter(c Doer, key string, limit uint32, window time.Duration) *Limiter {
+	return newLimiter(c, key, slidingScript, uint64(limit), micros(window))
+}
+
+func newLimiter(c Doer, key string, s *script, params ...uint64) *Limiter {
+	args := make([]string, len(params))
+	for i, p := range params {
+		args[i] = strconv.FormatUint(p, 10)
+	}
+
+	l := &Limiter{doer: c, key: key, script: s, args: args}
+	l.Decider = store.NewDecider(l.DecideNContext)
+
+	return l
+}
+
+// micros returns the window in whole microseconds, defaulting to a second
+// like the window package.
+func micros(window time.Duration) uint64 {
+	if window == 0 {
+		window = time.Second
+	}
+
+	return max(1, uint64(window/time.Microsecond))
+}
+
+// DecideNContext reports whether n requests are allowed at once, and the
+// limiter state, in a single script call. It passes ctx to the Doer and
+// returns its error, if any.
+func (l *Limiter) DecideNContext(ctx context.Context, n uint32) (rate.Decision, error) {
+	args := append(l.args[:len(l.args):len(l.args)], strconv.FormatUint(uint64(n), 10))
+
+	reply, err := l.script.run(ctx, l.doer, l.key, args...)
+	if err != nil {
+		return rate.Decision{}, err
+	}
+
+	vals, ok := reply.([]any)
+	if !ok || len(vals) != 5 {
+		return rate.Decision{}, fmt.Errorf("%w: %v", ErrUnexpectedReply, reply)
+	}
+
+	ints := make([]int64, len(vals))
+	for i, v := range vals {
+		if ints[i], ok = v.(int64); !ok {
+			return rate.Decision{}, fmt.Errorf("%w: %v", ErrUnexpectedReply, reply)
+		}
+	}
+
+	d := rate.Decision{
+		Allowed:    ints[0] == 1,
+		Limit:      uint32(ints[1]),
+		Remaining:  uint32(ints[2]),
+		RetryAfter: time.Duration(ints[4]) * time.Microsecond,
+	}
+
+	if ints[3] >= 0 {
+		d.ResetAt = time.UnixMicro(ints[3])
+	}
+
+	return d, nil
+}
+
+// Reset deletes the key from the server, so the limiter starts over like a
+// newly created one, e.g. to unblock a customer.
+func (l *Limiter) Reset(ctx context.Context) error {
+	_, err := l.doer.Do(ctx, "DEL", l.key)
+
+	return err
+}
+
+// RecoveredAt reports that the limiter may be evicted by a registry at any
+// time, as its whole state is kept in Redis.
+func (l *Limiter) RecoveredAt() (time.Time, bool) {
+	return time.Time{}, true
+}
@@ -0,0 +1,242 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/redis"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+type decider interface {
+	DecideN(n uint32) rate.Decision
+}
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newServer(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	m.SetTime(start)
+
+	c := redis.NewClient(m.Addr())
+	t.Cleanup(func() { c.Close() })
+
+	return m, c
+}
+
+// requireDecision requires got to match want up to the microsecond resolution
+// of the server's clock.
+func requireDecision(t *testing.T, want, got rate.Decision, msgAndArgs ...any) {
+	t.Helper()
+
+	require.Equal(t, want.Allowed, got.Allowed, msgAndArgs...)
+	require.Equal(t, want.Limit, got.Limit, msgAndArgs...)
+	require.Equal(t, want.Remaining, got.Remaining, msgAndArgs...)
+	require.WithinDuration(t, want.ResetAt, got.ResetAt, time.Microsecond, msgAndArgs...)
+	require.InDelta(t, want.RetryAfter, got.RetryAfter, float64(time.Microsecond), msgAndArgs...)
+}
+
+func TestLimiter_MatchesAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		local  func(clock *ratetest.Clock) decider
+		remote func(c redis.Doer) *redis.Limiter
+	}{
+		"token": {
+			local:  func(clock *ratetest.Clock) decider { return bucket.NewLimiterWithClock(5, 2, clock) },
+			remote: func(c redis.Doer) *redis.Limiter { return redis.NewTokenLimiter(c, "k", 5, 2) },
+		},
+		"leaky": {
+			local:  func(clock *ratetest.Clock) decider { return bucket.NewLeakyLimiterWithClock(5, 2, clock) },
+			remote: func(c redis.Doer) *redis.Limiter { return redis.NewLeakyLimiter(c, "k", 5, 2) },
+		},
+		"gcra": {
+			local:  func(clock *ratetest.Clock) decider { return bucket.NewGCRALimiterWithClock(2, 5, clock) },
+			remote: func(c redis.Doer) *redis.Limiter { return redis.NewGCRALimiter(c, "k", 2, 5) },
+		},
+		"fixed": {
+			local: func(clock *ratetest.Clock) decider {
+				return window.NewFixedLimiterWithClock(5, time.Second, clock)
+			},
+			remote: func(c redis.Doer) *redis.Limiter { return redis.NewFixedLimiter(c, "k", 5, time.Second) },
+		},
+		"sliding": {
+			local: func(clock *ratetest.Clock) decider {
+				return window.NewSlidingLimiterWithClock(5, time.Second, clock)
+			},
+			remote: func(c redis.Doer) *redis.Limiter { return redis.NewSlidingLimiter(c, "k", 5, time.Second) },
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m, c := newServer(t)
+			clock := ratetest.NewClock(start)
+			local, remote := tt.local(clock), tt.remote(c)
+
+			for i := range 200 {
+				if i%7 == 0 {
+					clock.Advance(time.Duration(i%5) * 100 * time.Millisecond)
+					m.SetTime(clock.Now())
+				}
+
+				n := uint32(i%3 + 1)
+				d, err := remote.DecideNContext(t.Context(), n)
+				require.NoError(t, err)
+				requireDecision(t, local.DecideN(n), d, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestLimiter_Expiry(t *testing.T) {
+	t.Parallel()
+
+	m, c := newServer(t)
+	lim := redis.NewTokenLimiter(c, "alice", 5, 1)
+
+	require.True(t, lim.AllowN(2))
+	require.True(t, m.Exists("alice"))
+	require.Equal(t, 2*time.Second, m.TTL("alice"))
+
+	// Once the bucket has refilled, the key is gone.
+	m.FastForward(2 * time.Second)
+	require.False(t, m.Exists("alice"))
+
+	// Decisions that leave the limiter fully recovered write nothing.
+	require.True(t, lim.AllowN(0))
+	require.False(t, m.Exists("alice"))
+}
+
+func TestLimiter_Forever(t *testing.T) {
+	t.Parallel()
+
+	m, c := newServer(t)
+	lim := redis.NewTokenLimiter(c, "alice", 1, 0)
+
+	require.True(t, lim.Allow())
+
+	d := lim.Decide()
+	require.False(t, d.Allowed)
+	require.True(t, d.ResetAt.IsZero())
+	require.Zero(t, d.RetryAfter)
+	require.True(t, m.Exists("alice"))
+	require.Zero(t, m.TTL("alice"))
+}
+
+func TestLimiter_SharedState(t *testing.T) {
+	t.Parallel()
+
+	m, c := newServer(t)
+	other := redis.NewClient(m.Addr())
+	t.Cleanup(func() { other.Close() })
+
+	// Two instances share the limit.
+	instances := []*redis.Limiter{
+		redis.NewFixedLimiter(c, "alice", 10, time.Minute),
+		redis.NewFixedLimiter(other, "alice", 10, time.Minute),
+	}
+
+	allowed := 0
+
+	for i := range 20 {
+		if instances[i%2].Allow() {
+			allowed++
+		}
+	}
+
+	require.Equal(t, 10, allowed)
+	require.True(t, redis.NewFixedLimiter(c, "bob", 10, time.Minute).Allow())
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	t.Parallel()
+
+	m, c := newServer(t)
+	lim := redis.NewTokenLimiter(c, "alice", 1, 0)
+
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	require.NoError(t, lim.Reset(t.Context()))
+	require.False(t, m.Exists("alice"))
+	require.True(t, lim.Allow())
+
+	m.Close()
+	require.Error(t, lim.Reset(t.Context()))
+}
+
+func TestLimiter_LoadsScript(t *testing.T) {
+	t.Parallel()
+
+	_, c := newServer(t)
+
+	var cmds []string
+
+	doer := redis.DoerFunc(func(ctx context.Context, args ...string) (any, error) {
+		cmds = append(cmds, args[0])
+
+		return c.Do(ctx, args...)
+	})
+
+	lim := redis.NewGCRALimiter(doer, "alice", 1, 1)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+	require.Equal(t, []string{"EVALSHA", "EVAL", "EVALSHA"}, cmds)
+}
+
+func TestLimiter_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		reply any
+		err   error
+	}{
+		"error":        {err: errTest},
+		"not an array": {reply: "OK", err: redis.ErrUnexpectedReply},
+		"short array":  {reply: []any{int64(1)}, err: redis.ErrUnexpectedReply},
+		"not integers": {reply: []any{int64(1), int64(1), "1", int64(1), int64(1)}, err: redis.ErrUnexpectedReply},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			doer := redis.DoerFunc(func(context.Context, ...string) (any, error) {
+				return tt.reply, tt.err
+			})
+			lim := redis.NewSlidingLimiter(doer, "alice", 10, 0)
+
+			_, err := lim.DecideNContext(t.Context(), 1)
+			require.ErrorIs(t, err, tt.err)
+
+			// Failures deny.
+			require.False(t, lim.Allow())
+			require.Equal(t, rate.Decision{}, lim.Decide())
+		})
+	}
+}
+
+func TestLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	_, c := newServer(t)
+	lim := redis.NewLeakyLimiter(c, "alice", 1, 1)
+	require.True(t, lim.Allow())
+
+	// The state lives in Redis, so the limiter can always be dropped.
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.True(t, at.IsZero())
+}
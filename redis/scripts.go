@@ -0,0 +1,249 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // Redis identifies scripts by their SHA1
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// The scripts decide on the state of KEYS[1] at the server's time, in
+// microseconds, with the same semantics as the limiters in the bucket and
+// window packages. They take the limiter parameters and n as ARGV and return
+// {allowed, limit, remaining, reset, retry}: reset is when the limiter will
+// have fully recovered (-1 if never) and retry is the time until n would be
+// allowed (0 if allowed or never), both in microseconds. State is only written
+// when it changes and expires once the limiter has fully recovered.
+
+// prelude is shared by all scripts. Reading TIME before writing requires
+// effects replication, which is the default since Redis 5.
+const prelude = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local function int(x)
+  return string.format('%.0f', x)
+end
+
+local function float(x)
+  return string.format('%.17g', x)
+end
+
+-- Sets the expiry of key to the recovery time at, deletes it if it has
+-- already recovered, or keeps it forever if at is negative.
+local function expire(key, at)
+  if at < 0 then
+    redis.call('PERSIST', key)
+  elseif at <= now then
+    redis.call('DEL', key)
+  else
+    redis.call('PEXPIREAT', key, int(math.ceil(at / 1000)))
+  end
+end
+`
+
+// ARGV: capacity, tokens per second, n.
+var tokenScript = newScript(prelude + `
+local capacity, rate, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local tokens, last = capacity, now
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+if state[1] then
+  tokens, last = math.min(capacity, tonumber(state[1])), tonumber(state[2])
+end
+
+if now > last then
+  tokens = math.min(capacity, tokens + (now - last) / 1000000 * rate)
+  last = now
+end
+
+local allowed, retry = 0, 0
+if tokens >= n then
+  tokens = tokens - n
+  allowed = 1
+elseif n <= capacity and rate > 0 then
+  retry = math.ceil((n - tokens) / rate * 1000000)
+end
+
+local reset = last
+if tokens < capacity then
+  reset = -1
+  if rate > 0 then
+    reset = last + math.ceil((capacity - tokens) / rate * 1000000)
+  end
+end
+
+if reset > now or reset < 0 then
+  redis.call('HSET', KEYS[1], 'tokens', float(tokens), 'last', int(last))
+end
+expire(KEYS[1], reset)
+
+return {allowed, capacity, math.floor(math.max(0, tokens)), reset, retry}
+`)
+
+// ARGV: capacity, leak rate per second, n.
+var leakyScript = newScript(prelude + `
+local capacity, rate, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local level, last = 0, now
+local state = redis.call('HMGET', KEYS[1], 'level', 'last')
+if state[1] then
+  level, last = tonumber(state[1]), tonumber(state[2])
+end
+
+if now > last then
+  level = math.max(0, level - (now - last) / 1000000 * rate)
+  last = now
+end
+
+local allowed, retry = 0, 0
+if level + n <= capacity then
+  level = level + n
+  allowed = 1
+elseif n <= capacity and rate > 0 then
+  retry = math.ceil((level + n - capacity) / rate * 1000000)
+end
+
+local reset = last
+if level > 0 then
+  reset = -1
+  if rate > 0 then
+    reset = last + math.ceil(level / rate * 1000000)
+  end
+end
+
+if reset > now or reset < 0 then
+  redis.call('HSET', KEYS[1], 'level', float(level), 'last', int(last))
+end
+expire(KEYS[1], reset)
+
+return {allowed, capacity, math.floor(math.max(0, capacity - level)), reset, retry}
+`)
+
+// ARGV: emission interval in microseconds, burst, n.
+var gcraScript = newScript(prelude + `
+local emission, burst, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local limit = emission * burst
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or 0
+
+local allowed, retry = 0, 0
+if n <= burst then
+  local new = math.max(now, tat) + n * emission
+  if new - limit > now then
+    retry = new - limit - now
+  else
+    tat = new
+    allowed = 1
+  end
+end
+
+local reset = math.max(now, tat)
+if allowed == 1 and n > 0 then
+  redis.call('SET', KEYS[1], int(tat))
+  expire(KEYS[1], tat)
+end
+
+return {allowed, burst, math.floor(math.max(0, now + limit - reset) / emission), reset, retry}
+`)
+
+// ARGV: limit, window in microseconds, n.
+var fixedScript = newScript(prelude + `
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local start = math.floor(now / window) * window
+
+local count = 0
+local state = redis.call('HMGET', KEYS[1], 'start', 'count')
+if state[1] and tonumber(state[1]) == start then
+  count = tonumber(state[2])
+end
+
+local allowed, retry = 0, 0
+if count + n <= limit then
+  allowed = 1
+  if n > 0 then
+    count = count + n
+    redis.call('HSET', KEYS[1], 'start', int(start), 'count', int(count))
+    expire(KEYS[1], start + window)
+  end
+elseif n <= limit then
+  retry = start + window - now
+end
+
+return {allowed, limit, math.max(0, limit - count), start + window, retry}
+`)
+
+// ARGV: limit, window in microseconds, n. The log is a sorted set scored by
+// time, with members "time:seq:n" so that entries of the same time and weight
+// stay distinct.
+var slidingScript = newScript(prelude + `
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local function weight(member)
+  return tonumber(string.match(member, ':(%d+)$'))
+end
+
+-- Entries still count while they are exactly window old.
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. int(now - window))
+local log = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+
+local count, newest = 0, nil
+for i = 1, #log, 2 do
+  count = count + weight(log[i])
+  newest = tonumber(log[i + 1])
+end
+
+local allowed, retry = 0, 0
+if count + n <= limit then
+  allowed = 1
+  if n > 0 then
+    local seq = redis.call('ZCOUNT', KEYS[1], int(now), int(now))
+    redis.call('ZADD', KEYS[1], int(now), int(now) .. ':' .. seq .. ':' .. n)
+    count, newest = count + n, now
+  end
+elseif n <= limit then
+  -- Walk from the oldest entry until enough weight would be freed.
+  local excess, freed, i = count + n - limit, 0, -1
+  while freed < excess do
+    i = i + 2
+    freed = freed + weight(log[i])
+  end
+  retry = tonumber(log[i + 1]) + window - now + 1
+end
+
+local reset = now
+if newest then
+  reset = newest + window + 1
+  expire(KEYS[1], reset)
+end
+
+return {allowed, limit, math.max(0, limit - count), reset, retry}
+`)
+
+type script struct {
+	src, sha string
+}
+
+func newScript(src string) *script {
+	sum := sha1.Sum([]byte(src)) //nolint:gosec // Redis identifies scripts by their SHA1
+
+	return &script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// run runs the script on key with args, by its SHA1 if the server has it
+// cached and by its source otherwise.
+func (s *script) run(ctx context.Context, c Doer, key string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", s.sha, "1", key}, args...)
+
+	reply, err := c.Do(ctx, cmd...)
+
+	var replyErr Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		reply, err = c.Do(ctx, cmd...)
+	}
+
+	return reply, err
+}
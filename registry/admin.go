@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// Len returns the number of keys the registry tracks.
+func (r *Registry) Len() int {
+	n := 0
+
+	for _, s := range r.shards {
+		s.mu.Lock()
+		n += len(s.limiters)
+		s.mu.Unlock()
+	}
+
+	return n
+}
+
+// Keys returns an iterator over the tracked keys, in no particular order.
+// It has the same guarantees as Range.
+func (r *Registry) Keys() iter.Seq[Identifier] {
+	return func(yield func(Identifier) bool) {
+		for key := range r.Range {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// Range calls f for each tracked key and its limiter, in no particular order,
+// until f returns false. It can be used as an iterator:
+//
+//	for key, lim := range reg.Range {
+//		...
+//	}
+//
+// No lock is held while f runs, so f may call into the registry. Keys are
+// collected shard by shard: a key added or removed while ranging may or may
+// not be seen, but no key is seen twice. Range does not mark keys as used.
+func (r *Registry) Range(f func(key Identifier, lim Limiter) bool) {
+	type pair struct {
+		key Identifier
+		lim Limiter
+	}
+
+	for _, s := range r.shards {
+		// Copy the limiters under the lock: Reset replaces them in place.
+		s.mu.Lock()
+		pairs := make([]pair, 0, len(s.limiters))
+		for key, e := range s.limiters {
+			pairs = append(pairs, pair{key: key, lim: e.lim})
+		}
+		s.mu.Unlock()
+
+		for _, p := range pairs {
+			if !f(p.key, p.lim) {
+				return
+			}
+		}
+	}
+}
+
+// Get returns key's limiter, if the registry tracks the key, without creating
+// it or marking the key as used.
+func (r *Registry) Get(key Identifier) (Limiter, bool) {
+	s := r.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.limiters[key]
+	if !ok {
+		return nil, false
+	}
+
+	return e.lim, true
+}
+
+// Delete stops tracking key and reports whether it was tracked. Its next
+// request gets a new limiter. If the key's limiter implements Resetter, the
+// state it keeps outside the registry is cleared as well, whether or not the
+// key was tracked; see DeleteContext. Unlike evictions, deletions are not
+// reported to the eviction callback or counted in Stats.
+func (r *Registry) Delete(key Identifier) bool {
+	ok, _ := r.DeleteContext(context.Background(), key)
+
+	return ok
+}
+
+// DeleteContext is like Delete but passes ctx to the limiter's Reset and
+// returns its error, if any.
+func (r *Registry) DeleteContext(ctx context.Context, key Identifier) (bool, error) {
+	s := r.shard(key)
+
+	var lim Limiter
+
+	s.mu.Lock()
+	e, ok := s.limiters[key]
+	if ok {
+		delete(s.limiters, key)
+		s.lru.Remove(e.elem)
+		lim = e.lim
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		lim = r.newLimiter(key)
+	}
+
+	return ok, r.reset(ctx, key, lim)
+}
+
+// Reset replaces key's limiter with a new one from the factory, e.g. to
+// unblock a customer, and reports whether the key was tracked. Its fallback
+// limiter, if any, is dropped too. If the new limiter implements Resetter,
+// the state it keeps outside the registry, e.g. in a store, is cleared, so
+// untracked keys are reset as well; see ResetContext. Callers still holding
+// the old limiter, such as a pending Wait, keep using it.
+func (r *Registry) Reset(key Identifier) bool {
+	ok, _ := r.ResetContext(context.Background(), key)
+
+	return ok
+}
+
+// ResetContext is like Reset but passes ctx to the limiter's Reset and
+// returns its error, if any.
+func (r *Registry) ResetContext(ctx context.Context, key Identifier) (bool, error) {
+	lim := r.newLimiter(key)
+	s := r.shard(key)
+
+	s.mu.Lock()
+	e, ok := s.limiters[key]
+	if ok {
+		e.lim, e.fallback = lim, nil
+	}
+	s.mu.Unlock()
+
+	return ok, r.reset(ctx, key, lim)
+}
+
+// ResetAll replaces the limiter of every tracked key with a new one from the
+// factory, clearing the state of those implementing Resetter. Keys with state
+// outside the registry that it does not track, e.g. because their handles
+// were evicted, are not reset; use Reset for them.
+func (r *Registry) ResetAll() {
+	_ = r.ResetAllContext(context.Background())
+}
+
+// ResetAllContext is like ResetAll but passes ctx to the limiters' Reset and
+// returns their errors, if any. It resets all keys even if some fail.
+func (r *Registry) ResetAllContext(ctx context.Context) error {
+	var errs []error
+
+	for _, s := range r.shards {
+		reset := make(map[Identifier]Limiter)
+
+		s.mu.Lock()
+		for key, e := range s.limiters {
+			e.lim, e.fallback = r.newLimiter(key), nil
+			reset[key] = e.lim
+		}
+		s.mu.Unlock()
+
+		for key, lim := range reset {
+			if err := r.reset(ctx, key, lim); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// reset clears the state that key's limiter lim keeps outside the registry,
+// if it implements Resetter. It is called without any lock held.
+func (r *Registry) reset(ctx context.Context, key Identifier, lim Limiter) error {
+	rs, ok := lim.(Resetter)
+	if !ok {
+		return nil
+	}
+
+	if err := rs.Reset(ctx); err != nil {
+		return fmt.Errorf("registry: resetting %q: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package registry_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/registry"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminRegistry(t *testing.T) *registry.Registry {
+	t.Helper()
+
+	var evicted int
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithKeys("alice", "bob", "carol"), registry.WithOnEvict(func(registry.Identifier, registry.Limiter) {
+		evicted++
+	}))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.Zero(t, evicted)
+		require.Equal(t, registry.Stats{}, reg.Stats())
+	})
+
+	return reg
+}
+
+func TestRegistry_Len(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+	require.Equal(t, 3, reg.Len())
+
+	reg.Allow("dave")
+	require.Equal(t, 4, reg.Len())
+}
+
+func TestRegistry_Keys(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+
+	keys := slices.Sorted(reg.Keys())
+	require.Equal(t, []registry.Identifier{"alice", "bob", "carol"}, keys)
+
+	// Stops early
+	for range reg.Keys() {
+		break
+	}
+}
+
+func TestRegistry_Range(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+	require.True(t, reg.Allow("alice"))
+
+	seen := map[registry.Identifier]uint32{}
+
+	for key, lim := range reg.Range {
+		seen[key] = lim.(*bucket.TokenLimiter).DecideN(0).Remaining
+
+		// The registry can be used while ranging
+		reg.Get(key)
+	}
+
+	require.Equal(t, map[registry.Identifier]uint32{"alice": 0, "bob": 1, "carol": 1}, seen)
+
+	n := 0
+
+	reg.Range(func(registry.Identifier, registry.Limiter) bool {
+		n++
+
+		return false
+	})
+	require.Equal(t, 1, n)
+}
+
+func TestRegistry_Get(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+
+	lim, ok := reg.Get("alice")
+	require.True(t, ok)
+	require.True(t, lim.Allow())
+	require.False(t, reg.Allow("alice"))
+
+	// Does not create keys
+	_, ok = reg.Get("dave")
+	require.False(t, ok)
+	require.Equal(t, 3, reg.Len())
+}
+
+func TestRegistry_Delete(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+	require.True(t, reg.Allow("alice"))
+
+	require.True(t, reg.Delete("alice"))
+	require.False(t, reg.Delete("alice"))
+	require.Equal(t, 2, reg.Len())
+
+	_, ok := reg.Get("alice")
+	require.False(t, ok)
+
+	// Comes back with a fresh limiter
+	require.True(t, reg.Allow("alice"))
+}
+
+func TestRegistry_Reset(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+
+	require.True(t, reg.Reset("alice"))
+	require.False(t, reg.Reset("dave"))
+	require.Equal(t, 3, reg.Len())
+
+	require.True(t, reg.Allow("alice"))
+	require.False(t, reg.Allow("bob"))
+}
+
+func TestRegistry_ResetAll(t *testing.T) {
+	t.Parallel()
+
+	reg := newAdminRegistry(t)
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+
+	reg.ResetAll()
+	require.Equal(t, 3, reg.Len())
+
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+}
+
+func TestRegistry_Reset_Store(t *testing.T) {
+	t.Parallel()
+
+	st := store.NewMemory()
+
+	reg, err := registry.NewWithStore(st, tokens(1))
+	require.NoError(t, err)
+
+	require.True(t, reg.Allow("alice"))
+	require.False(t, reg.Allow("alice"))
+
+	// The state in the store is cleared, not just the handle replaced
+	require.True(t, reg.Reset("alice"))
+	require.True(t, reg.Allow("alice"))
+
+	require.True(t, reg.Delete("alice"))
+	require.True(t, reg.Allow("alice"))
+
+	require.True(t, reg.Allow("bob"))
+	reg.ResetAll()
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+
+	// Keys the registry does not track are reset in the store too
+	other, err := registry.NewWithStore(st, tokens(1))
+	require.NoError(t, err)
+
+	require.False(t, reg.Allow("bob"))
+	require.False(t, other.Reset("bob"))
+	require.True(t, reg.Allow("bob"))
+	require.False(t, other.Delete("bob"))
+	require.True(t, reg.Allow("bob"))
+}
+
+func TestRegistry_Reset_StoreError(t *testing.T) {
+	t.Parallel()
+
+	st := newFlakyStore()
+
+	reg, err := registry.NewWithStore(st, tokens(1))
+	require.NoError(t, err)
+	require.True(t, reg.Allow("alice"))
+
+	st.down.Store(true)
+
+	ok, err := reg.ResetContext(t.Context(), "alice")
+	require.True(t, ok)
+	require.ErrorIs(t, err, errDown)
+	require.ErrorContains(t, err, `"alice"`)
+
+	require.ErrorIs(t, reg.ResetAllContext(t.Context()), errDown)
+
+	ok, err = reg.DeleteContext(t.Context(), "alice")
+	require.True(t, ok)
+	require.ErrorIs(t, err, errDown)
+}
+
+func TestRegistry_Range_ConcurrentReset(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithShards(1), registry.WithKeys("alice", "bob"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+
+	wg.Go(func() {
+		for range 100 {
+			reg.Reset("alice")
+			reg.ResetAll()
+		}
+	})
+
+	// Run with -race: ranging must not race with the limiters being replaced
+	for range 100 {
+		for _, lim := range reg.Range {
+			require.NotNil(t, lim)
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/serroba/rate"
+)
+
+// FailurePolicy is what a registry does with a request whose limiter fails to
+// decide, e.g. because the store holding its state is down.
+type FailurePolicy int
+
+const (
+	// FailClosed denies the request. It is the default, and what limiters
+	// that can fail do on their own.
+	FailClosed FailurePolicy = iota
+	// FailOpen allows the request.
+	FailOpen
+	// FailLocal decides the request with a local limiter for the key, built
+	// by the factory given with WithFallback, so limits are still enforced
+	// per instance while the shared state is out of reach.
+	FailLocal
+)
+
+// ContextDecider is implemented by limiters that can fail to decide, such as
+// those keeping their state in a store.Store or on a Redis server. A failed
+// decision is reported as an error instead of a denial, so the registry can
+// apply its FailurePolicy.
+type ContextDecider interface {
+	Limiter
+	DecideNContext(ctx context.Context, n uint32) (rate.Decision, error)
+}
+
+// FailurePolicy returns what the registry does with requests whose limiter
+// fails to decide.
+func (r *Registry) FailurePolicy() FailurePolicy {
+	return r.policy
+}
+
+// DecideContext is like Decide but passes ctx to the key's limiter and
+// returns its error, if any.
+func (r *Registry) DecideContext(ctx context.Context, key Identifier) (rate.Decision, error) {
+	return r.DecideNContext(ctx, key, 1)
+}
+
+// DecideNContext is like DecideN but passes ctx to the key's limiter, if it
+// implements ContextDecider, and returns its error, if any. If the limiter
+// fails, the request is decided by the registry's FailurePolicy and the
+// error is returned along with that decision.
+func (r *Registry) DecideNContext(ctx context.Context, key Identifier, n uint32) (rate.Decision, error) {
+	return r.decideN(ctx, key, r.get(key), n)
+}
+
+// decideKey decides n requests for key with its limiter lim, applying the
+// failure policy if it fails or takes longer than the decision timeout.
+func (r *Registry) decideKey(ctx context.Context, key Identifier, lim Limiter, n uint32) (rate.Decision, error) {
+	cd, ok := lim.(ContextDecider)
+	if !ok {
+		return decideN(lim, n), nil
+	}
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	d, err := cd.DecideNContext(ctx, n)
+	if err == nil {
+		return d, nil
+	}
+
+	r.failures.Add(1)
+
+	if r.onFailure != nil {
+		r.onFailure(key, err)
+	}
+
+	switch r.policy {
+	case FailOpen:
+		d = rate.Decision{Allowed: true}
+	case FailLocal:
+		d = decideN(r.fallback(key), n)
+	case FailClosed:
+		d = rate.Decision{}
+	}
+
+	return d, fmt.Errorf("registry: deciding %q: %w", key, err)
+}
+
+// fallback returns key's local limiter for FailLocal, creating it if needed.
+// It lives as long as the key is tracked.
+func (r *Registry) fallback(key Identifier) Limiter {
+	s := r.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(r, key)
+
+	e := s.limiters[key]
+	if e.fallback == nil {
+		e.fallback = r.fallbackFactory()
+	}
+
+	return e.fallback
+}
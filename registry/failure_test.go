@@ -0,0 +1,250 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/registry"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("store down")
+
+// flakyStore is a memory store that fails every update while down.
+type flakyStore struct {
+	*store.Memory
+
+	down atomic.Bool
+}
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{Memory: store.NewMemory()}
+}
+
+func (s *flakyStore) Update(
+	ctx context.Context, key string, fn func([]byte) ([]byte, time.Time, error),
+) error {
+	if s.down.Load() {
+		return errDown
+	}
+
+	return s.Memory.Update(ctx, key, fn)
+}
+
+func tokens(capacity uint32) store.AlgorithmFactory {
+	return func() store.Algorithm { return bucket.NewTokenLimiter(capacity, 0) }
+}
+
+func TestRegistry_FailClosed(t *testing.T) {
+	t.Parallel()
+
+	st := newFlakyStore()
+
+	reg, err := registry.NewWithStore(st, tokens(3))
+	require.NoError(t, err)
+	require.Equal(t, registry.FailClosed, reg.FailurePolicy())
+
+	d, err := reg.DecideContext(context.Background(), "alice")
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(2), d.Remaining)
+
+	st.down.Store(true)
+
+	d, err = reg.DecideContext(context.Background(), "alice")
+	require.ErrorIs(t, err, errDown)
+	require.ErrorContains(t, err, `"alice"`)
+	require.False(t, d.Allowed)
+
+	require.False(t, reg.Allow("alice"))
+	require.False(t, reg.Decide("alice").Allowed)
+	require.Equal(t, uint64(3), reg.Stats().Failures)
+
+	// Decisions resume where they left off once the store is back
+	st.down.Store(false)
+
+	d, err = reg.DecideNContext(context.Background(), "alice", 2)
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+	require.Zero(t, d.Remaining)
+}
+
+func TestRegistry_FailOpen(t *testing.T) {
+	t.Parallel()
+
+	st := newFlakyStore()
+	st.down.Store(true)
+
+	reg, err := registry.NewWithStore(st, tokens(1), registry.WithFailurePolicy(registry.FailOpen))
+	require.NoError(t, err)
+
+	for range 3 {
+		d, err := reg.DecideContext(context.Background(), "alice")
+		require.ErrorIs(t, err, errDown)
+		require.True(t, d.Allowed)
+	}
+
+	require.True(t, reg.AllowN("alice", 5))
+	require.True(t, reg.DecideN("alice", 5).Allowed)
+	require.Equal(t, uint64(5), reg.Stats().Failures)
+}
+
+func TestRegistry_FailLocal(t *testing.T) {
+	t.Parallel()
+
+	st := newFlakyStore()
+	st.down.Store(true)
+
+	var built atomic.Int32
+
+	reg, err := registry.NewWithStore(st, tokens(10),
+		registry.WithFailurePolicy(registry.FailLocal),
+		registry.WithFallback(func() registry.Limiter {
+			built.Add(1)
+
+			return bucket.NewTokenLimiter(2, 0)
+		}),
+	)
+	require.NoError(t, err)
+
+	d, err := reg.DecideContext(context.Background(), "alice")
+	require.ErrorIs(t, err, errDown)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(2), d.Limit)
+	require.Equal(t, uint32(1), d.Remaining)
+
+	require.True(t, reg.Allow("alice"))
+	require.False(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+
+	// One fallback per key, kept across failures
+	require.Equal(t, int32(2), built.Load())
+
+	// Resetting the key drops its fallback
+	require.True(t, reg.Reset("alice"))
+	require.True(t, reg.Allow("alice"))
+	require.Equal(t, int32(3), built.Load())
+
+	// The shared state is used again once the store is back
+	st.down.Store(false)
+	require.True(t, reg.AllowN("alice", 10))
+}
+
+func TestRegistry_OnFailure(t *testing.T) {
+	t.Parallel()
+
+	st := newFlakyStore()
+	st.down.Store(true)
+
+	var (
+		keys []registry.Identifier
+		errs []error
+	)
+
+	reg, err := registry.NewWithStore(st, tokens(1),
+		registry.WithOnFailure(func(key registry.Identifier, err error) {
+			keys = append(keys, key)
+			errs = append(errs, err)
+		}),
+	)
+	require.NoError(t, err)
+
+	require.False(t, reg.Allow("alice"))
+	require.False(t, reg.Allow("bob"))
+
+	require.Equal(t, []registry.Identifier{"alice", "bob"}, keys)
+	require.Equal(t, []error{errDown, errDown}, errs)
+}
+
+func TestRegistry_DecideContext_WithoutContextDecider(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	})
+	require.NoError(t, err)
+
+	d, err := reg.DecideContext(context.Background(), "alice")
+	require.NoError(t, err)
+	require.True(t, d.Allowed)
+
+	d, err = reg.DecideContext(context.Background(), "alice")
+	require.NoError(t, err)
+	require.False(t, d.Allowed)
+	require.Zero(t, reg.Stats().Failures)
+}
+
+func TestRegistry_DecideContext_Canceled(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewWithStore(store.NewMemory(), tokens(1))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d, err := reg.DecideContext(ctx, "alice")
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, d.Allowed)
+}
+
+// stalledStore is a store whose updates never finish before their context
+// is done.
+type stalledStore struct{}
+
+func (stalledStore) Update(ctx context.Context, _ string, _ func([]byte) ([]byte, time.Time, error)) error {
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func TestRegistry_DecisionTimeout(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewWithStore(stalledStore{}, tokens(1),
+		registry.WithDecisionTimeout(10*time.Millisecond),
+		registry.WithFailurePolicy(registry.FailOpen),
+	)
+	require.NoError(t, err)
+
+	d, err := reg.DecideContext(context.Background(), "alice")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, d.Allowed)
+
+	require.True(t, reg.Allow("alice"))
+	require.Equal(t, uint64(2), reg.Stats().Failures)
+}
+
+func TestFailureOptions_Invalid(t *testing.T) {
+	t.Parallel()
+
+	factory := func() registry.Limiter { return bucket.NewTokenLimiter(1, 0) }
+
+	tests := []struct {
+		name string
+		opts []registry.Option
+	}{
+		{name: "unknown policy", opts: []registry.Option{registry.WithFailurePolicy(registry.FailLocal + 1)}},
+		{name: "negative policy", opts: []registry.Option{registry.WithFailurePolicy(-1)}},
+		{name: "nil fallback", opts: []registry.Option{registry.WithFallback(nil)}},
+		{name: "negative timeout", opts: []registry.Option{registry.WithDecisionTimeout(-time.Second)}},
+		{
+			name: "FailLocal without fallback",
+			opts: []registry.Option{registry.WithFailurePolicy(registry.FailLocal)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := registry.New(factory, tt.opts...)
+			require.ErrorIs(t, err, registry.ErrInvalidOption)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// ErrInvalidOption is returned by New when an option is given an invalid value.
+var ErrInvalidOption = errors.New("registry: invalid option")
+
+// Option configures a Registry created with New.
+type Option func(*config) error
+
+type config struct {
+	keys      []Identifier
+	clock     clock.Clock
+	idleTTL   time.Duration
+	maxKeys   int
+	onEvict   func(Identifier, Limiter)
+	shards    int
+	overrides map[Identifier]LimiterFactory
+	policy    FailurePolicy
+	fallback  LimiterFactory
+	onFailure func(Identifier, error)
+	timeout   time.Duration
+	global    Refunder
+}
+
+// WithKeys creates limiters for keys up front instead of on first use.
+func WithKeys(keys ...Identifier) Option {
+	return func(c *config) error {
+		c.keys = append(c.keys, keys...)
+
+		return nil
+	}
+}
+
+// WithOverrides builds the limiters of the given keys with their own factory
+// instead of the registry's, e.g. to give internal services a higher limit or
+// to block specific keys. Later overrides of the same key win.
+func WithOverrides(overrides map[Identifier]LimiterFactory) Option {
+	return func(c *config) error {
+		if c.overrides == nil {
+			c.overrides = make(map[Identifier]LimiterFactory, len(overrides))
+		}
+
+		for key, factory := range overrides {
+			if factory == nil {
+				return ErrInvalidOption
+			}
+
+			c.overrides[key] = factory
+		}
+
+		return nil
+	}
+}
+
+// WithClock sets the clock used to track when keys were last used.
+// It defaults to the system clock.
+func WithClock(clk clock.Clock) Option {
+	return func(c *config) error {
+		if clk == nil {
+			return ErrInvalidOption
+		}
+
+		c.clock = clk
+
+		return nil
+	}
+}
+
+// WithIdleTTL evicts keys that have not been used for ttl, so the registry
+// does not grow forever as new keys are seen. Keys whose limiter implements
+// Recoverer are kept until it has fully recovered as well, so evicting a key
+// never changes a decision. Idle keys are swept lazily, at most once per ttl
+// and shard, by calls for keys in the same shard. Zero disables eviction.
+func WithIdleTTL(ttl time.Duration) Option {
+	return func(c *config) error {
+		if ttl < 0 {
+			return ErrInvalidOption
+		}
+
+		c.idleTTL = ttl
+
+		return nil
+	}
+}
+
+// WithMaxKeys caps the number of keys the registry tracks. When a new key
+// would exceed the cap, the least recently used key of its shard is evicted,
+// whether or not its limiter has recovered: its next request starts from a
+// fresh limiter. Zero means no cap.
+func WithMaxKeys(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return ErrInvalidOption
+		}
+
+		c.maxKeys = n
+
+		return nil
+	}
+}
+
+// WithOnEvict sets a function called with every key evicted from the registry,
+// whether for being idle or to stay within the max keys, and its limiter. It
+// is called with the lock of the key's shard held and must not call back into
+// the registry.
+func WithOnEvict(fn func(key Identifier, lim Limiter)) Option {
+	return func(c *config) error {
+		c.onEvict = fn
+
+		return nil
+	}
+}
+
+// WithShards sets how many shards the registry's keys are split into. Each
+// shard has its own lock, so more shards mean less contention between keys.
+// With WithMaxKeys the cap is split evenly across shards and the least
+// recently used key of a shard is evicted, which approximates LRU over the
+// whole registry; use a single shard for exact LRU. It defaults to 32.
+func WithShards(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return ErrInvalidOption
+		}
+
+		c.shards = n
+
+		return nil
+	}
+}
+
+// WithFailurePolicy sets what the registry does with requests whose limiter
+// fails to decide; see FailurePolicy. It defaults to FailClosed. FailLocal
+// also needs WithFallback.
+func WithFailurePolicy(p FailurePolicy) Option {
+	return func(c *config) error {
+		if p < FailClosed || p > FailLocal {
+			return ErrInvalidOption
+		}
+
+		c.policy = p
+
+		return nil
+	}
+}
+
+// WithFallback sets the factory of the local limiters that decide, under
+// FailLocal, the requests of keys whose limiter fails. A key's fallback
+// limiter is created on its first failure and kept as long as the key.
+func WithFallback(factory LimiterFactory) Option {
+	return func(c *config) error {
+		if factory == nil {
+			return ErrInvalidOption
+		}
+
+		c.fallback = factory
+
+		return nil
+	}
+}
+
+// WithOnFailure sets a function called with the key and the error whenever a
+// key's limiter fails to decide, e.g. to log failures or alert on them. It is
+// called without any lock held, before the failure policy decides.
+func WithOnFailure(fn func(key Identifier, err error)) Option {
+	return func(c *config) error {
+		c.onFailure = fn
+
+		return nil
+	}
+}
+
+// WithDecisionTimeout bounds how long a limiter implementing ContextDecider
+// may take to decide, on top of the deadline of the request's context, if
+// any. A decision that takes longer fails with context.DeadlineExceeded and
+// the failure policy decides instead, so a stalled store or server cannot
+// block requests, e.g. those of Allow, which have no deadline. Zero, the
+// default, leaves decisions to the context and the limiters' own timeouts.
+func WithDecisionTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		if timeout < 0 {
+			return ErrInvalidOption
+		}
+
+		c.timeout = timeout
+
+		return nil
+	}
+}
+
+// WithGlobal sets a limiter that every request must pass as well as its key's
+// limiter, e.g. a service-wide ceiling protecting a shared database. The
+// global limiter is asked first and, if the key's limiter then denies, the
+// requests are refunded to it, so requests denied for their key never use up
+// the global limit. It applies to every method deciding requests, including
+// Wait, TryAcquire and Acquire, for which the global limiter must implement
+// Waiter.
+func WithGlobal(lim Refunder) Option {
+	return func(c *config) error {
+		if lim == nil {
+			return ErrInvalidOption
+		}
+
+		c.global = lim
+
+		return nil
+	}
+}
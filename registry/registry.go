@@ -1,46 +1,452 @@
 package registry
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"sync/atomic"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/store"
 )
 
+// ErrWaitNotSupported is returned by Wait, WaitN and Acquire when the key's
+// limiter does not implement Waiter (or Acquirer, for Acquire).
+var ErrWaitNotSupported = errors.New("registry: limiter does not support waiting")
+
 type (
 	Identifier string
-	Registry   struct {
-		mu       sync.Mutex
-		factory  LimiterFactory
-		limiters map[Identifier]Limiter
+
+	// Registry holds a limiter per key. Keys are hash-partitioned into
+	// shards, each with its own lock, and the lock is only held to look up or
+	// create a key's limiter: the limiter itself is called without it, so
+	// limiters must be safe for concurrent use.
+	Registry struct {
+		factory         KeyedFactory
+		overrides       map[Identifier]LimiterFactory
+		clock           clock.Clock
+		idleTTL         time.Duration
+		onEvict         func(Identifier, Limiter)
+		policy          FailurePolicy
+		fallbackFactory LimiterFactory
+		onFailure       func(Identifier, error)
+		timeout         time.Duration
+		global          Refunder
+		failures        atomic.Uint64
+		seed            maphash.Seed
+		shards          []*shard
 	}
 )
 
+// Stats counts keys dropped by a registry, e.g. to alarm on churn.
+type Stats struct {
+	// Expired is the number of keys evicted for being idle.
+	Expired uint64
+	// Evicted is the number of keys evicted to stay within the max keys.
+	Evicted uint64
+	// Failures is the number of decisions whose limiter failed and that were
+	// made by the failure policy instead. With FailLocal it counts how often
+	// the fallback limiters were used.
+	Failures uint64
+}
+
 type Limiter interface {
 	Allow() bool
 }
 
+// NLimiter is implemented by limiters that support weighted consumption,
+// such as charging a request by bytes or rows instead of by one unit.
+type NLimiter interface {
+	Limiter
+	AllowN(n uint32) bool
+}
+
+// Decider is implemented by limiters that report their state along with each
+// decision, such as the remaining quota and when it resets.
+type Decider interface {
+	Limiter
+	DecideN(n uint32) rate.Decision
+}
+
+// Waiter is implemented by limiters that can block until permits are available.
+type Waiter interface {
+	Limiter
+	WaitN(ctx context.Context, n uint32) error
+}
+
+// Acquirer is implemented by limiters that cap concurrent work rather than a
+// rate, such as concurrency.Limiter. A successful acquisition returns a
+// function that must be called to release the slot. As Allow, AllowN, Decide
+// and DecideN return no such function, they only report whether a slot is
+// free for key, without holding it; use TryAcquire or Acquire to hold one.
+type Acquirer interface {
+	Limiter
+	TryAcquire() (func(), bool)
+	Acquire(ctx context.Context) (func(), error)
+}
+
+// Refunder is implemented by limiters that can give back requests they
+// allowed, such as those of the bucket, window and composite packages.
+type Refunder interface {
+	Limiter
+	RefundN(n uint32)
+}
+
+// Resetter is implemented by limiters that keep their state outside the
+// registry, such as store.Limiter or redis.Limiter, so that it survives
+// replacing the limiter. Reset clears that state, so the limiter starts over
+// like a newly created one.
+type Resetter interface {
+	Limiter
+	Reset(ctx context.Context) error
+}
+
+// Recoverer is implemented by limiters that can tell when they will have
+// fully recovered from past requests, i.e. behave exactly like a newly created
+// limiter. ok is false if that will not happen on its own, e.g. a bucket
+// that never refills or a concurrency limiter with slots held.
+type Recoverer interface {
+	RecoveredAt() (t time.Time, ok bool)
+}
+
 type LimiterFactory func() Limiter
 
+// KeyedFactory builds the limiter for a key, so different keys can get
+// different limits, e.g. by the customer's plan.
+type KeyedFactory func(key Identifier) Limiter
+
 func NewRegistry(factory LimiterFactory, keys ...Identifier) (*Registry, error) {
-	limiters := make(map[Identifier]Limiter)
+	return New(factory, WithKeys(keys...))
+}
+
+// New creates a registry that builds a limiter with factory for each new key.
+// It returns ErrInvalidOption if an option is given an invalid value.
+func New(factory LimiterFactory, opts ...Option) (*Registry, error) {
+	return NewKeyed(func(Identifier) Limiter { return factory() }, opts...)
+}
+
+// NewKeyed is like New but passes each new key to factory.
+func NewKeyed(factory KeyedFactory, opts ...Option) (*Registry, error) {
+	cfg := config{clock: clock.Real{}, shards: defaultShards}
+
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.policy == FailLocal && cfg.fallback == nil {
+		return nil, fmt.Errorf("%w: FailLocal without a fallback", ErrInvalidOption)
+	}
+
+	r := &Registry{
+		factory:         factory,
+		overrides:       cfg.overrides,
+		clock:           cfg.clock,
+		idleTTL:         cfg.idleTTL,
+		onEvict:         cfg.onEvict,
+		policy:          cfg.policy,
+		fallbackFactory: cfg.fallback,
+		onFailure:       cfg.onFailure,
+		timeout:         cfg.timeout,
+		global:          cfg.global,
+		seed:            maphash.MakeSeed(),
+	}
+
+	// A cap is split evenly across the shards, so there cannot be more
+	// shards than keys.
+	n := cfg.shards
+	if cfg.maxKeys > 0 {
+		n = min(n, cfg.maxKeys)
+	}
 
-	for _, key := range keys {
-		limiters[key] = factory()
+	now := r.clock.Now()
+	r.shards = make([]*shard, n)
+
+	for i := range r.shards {
+		maxKeys := 0
+		if cfg.maxKeys > 0 {
+			maxKeys = cfg.maxKeys / n
+			if i < cfg.maxKeys%n {
+				maxKeys++
+			}
+		}
+
+		r.shards[i] = newShard(maxKeys, now.Add(r.idleTTL))
+	}
+
+	for _, key := range cfg.keys {
+		s := r.shard(key)
+		if _, ok := s.limiters[key]; !ok {
+			s.insert(r, key, now)
+		}
+	}
+
+	return r, nil
+}
+
+// NewWithStore creates a registry whose limiters keep their state in st, so
+// that registries of several instances sharing st enforce the same limits.
+// Each key's limiter runs the algorithm built by factory on the key's state in
+// st; see store.Limiter. The registry itself only tracks lightweight handles,
+// which idle eviction can drop at any time. Reset, ResetAll and Delete clear
+// the keys' state in st. Snapshot leaves the keys out, as their state is not
+// held by the registry but already kept in st.
+func NewWithStore(st store.Store, factory store.AlgorithmFactory, opts ...Option) (*Registry, error) {
+	return NewKeyed(func(key Identifier) Limiter {
+		return store.NewLimiter(st, string(key), factory)
+	}, opts...)
+}
+
+// Stats returns how many keys the registry has evicted and how many of its
+// limiters' decisions have failed so far.
+func (r *Registry) Stats() Stats {
+	stats := Stats{Failures: r.failures.Load()}
+
+	for _, s := range r.shards {
+		s.mu.Lock()
+		stats.Expired += s.stats.Expired
+		stats.Evicted += s.stats.Evicted
+		s.mu.Unlock()
 	}
 
-	return &Registry{
-		limiters: limiters,
-		factory:  factory,
-	}, nil
+	return stats
 }
 
 func (r *Registry) Allow(key Identifier) bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.AllowN(key, 1)
+}
+
+// AllowN reports whether n units may be consumed at once for key. If the
+// key's limiter does not implement NLimiter, only n == 1 can be honoured:
+// n == 0 is always allowed and any larger n is denied. If the limiter
+// implements ContextDecider and fails, the registry's FailurePolicy decides.
+// With WithGlobal the units must be allowed by the global limiter too.
+func (r *Registry) AllowN(key Identifier, n uint32) bool {
+	lim := r.get(key)
+	if _, ok := lim.(ContextDecider); ok || r.global != nil {
+		d, _ := r.decideN(context.Background(), key, lim, n)
+
+		return d.Allowed
+	}
+
+	return allowN(lim, n)
+}
+
+// Decide is like Allow but also reports the state of the key's limiter.
+func (r *Registry) Decide(key Identifier) rate.Decision {
+	return r.DecideN(key, 1)
+}
+
+// DecideN is like AllowN but also reports the state of the key's limiter.
+// If the limiter does not implement Decider, only Allowed is set. If it
+// implements ContextDecider and fails, the registry's FailurePolicy decides.
+// With WithGlobal the global limiter's state is reported instead if it
+// denied or has fewer requests remaining.
+func (r *Registry) DecideN(key Identifier, n uint32) rate.Decision {
+	d, _ := r.DecideNContext(context.Background(), key, n)
+
+	return d
+}
+
+// decideN decides n requests for key with its limiter lim and the global
+// limiter, if any. The global limiter is asked first and refunded if the
+// key's limiter denies. Of two allowing decisions, the one with fewer
+// requests remaining is returned.
+func (r *Registry) decideN(ctx context.Context, key Identifier, lim Limiter, n uint32) (rate.Decision, error) {
+	if r.global == nil {
+		return r.decideKey(ctx, key, lim, n)
+	}
 
-	lim, ok := r.limiters[key]
+	g := decideN(r.global, n)
+	if !g.Allowed {
+		return g, nil
+	}
+
+	d, err := r.decideKey(ctx, key, lim, n)
+	if !d.Allowed {
+		r.global.RefundN(n)
+
+		return d, err
+	}
+
+	if g.Remaining < d.Remaining {
+		return g, err
+	}
+
+	return d, err
+}
+
+func decideN(lim Limiter, n uint32) rate.Decision {
+	if d, ok := lim.(Decider); ok {
+		return d.DecideN(n)
+	}
+
+	return rate.Decision{Allowed: allowN(lim, n)}
+}
+
+// allowN decides n requests with lim. Acquirers are only asked whether a slot
+// is free, as the slot could never be released.
+func allowN(lim Limiter, n uint32) bool {
+	if a, ok := lim.(Acquirer); ok {
+		return n == 0 || (n == 1 && slotFree(a))
+	}
+
+	if nl, ok := lim.(NLimiter); ok {
+		return nl.AllowN(n)
+	}
+
+	switch n {
+	case 0:
+		return true
+	case 1:
+		return lim.Allow()
+	default:
+		return false
+	}
+}
+
+// slotFree reports whether a has a free slot, taking and releasing it at once.
+func slotFree(a Acquirer) bool {
+	release, ok := a.TryAcquire()
+	if ok {
+		release()
+	}
+
+	return ok
+}
+
+// Wait blocks until a request for key is allowed or ctx is done.
+func (r *Registry) Wait(ctx context.Context, key Identifier) error {
+	return r.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until n units for key are allowed or ctx is done. Waiting on
+// one key never blocks other keys. It returns ErrWaitNotSupported if the key's
+// limiter does not implement Waiter. With WithGlobal the units are waited for
+// on the global limiter first, which must implement Waiter too, and refunded
+// to it if waiting on the key's limiter fails.
+func (r *Registry) WaitN(ctx context.Context, key Identifier, n uint32) error {
+	w, ok := r.get(key).(Waiter)
+	if !ok {
+		return ErrWaitNotSupported
+	}
+
+	return r.withGlobal(ctx, n, func() error {
+		return w.WaitN(ctx, n)
+	})
+}
+
+// TryAcquire takes a slot from key's limiter without blocking and returns the
+// function that releases it. If the limiter does not implement Acquirer it is
+// treated as a rate limiter: TryAcquire calls Allow and the release function
+// does nothing. With WithGlobal each acquisition also takes a request from
+// the global limiter, which is not given back on release.
+func (r *Registry) TryAcquire(key Identifier) (func(), bool) {
+	lim := r.get(key)
+
+	a, ok := lim.(Acquirer)
 	if !ok {
-		lim = r.factory()
-		r.limiters[key] = lim
+		if d, _ := r.decideN(context.Background(), key, lim, 1); !d.Allowed {
+			return nil, false
+		}
+
+		return func() {}, true
+	}
+
+	if r.global != nil && !allowN(r.global, 1) {
+		return nil, false
+	}
+
+	release, ok := a.TryAcquire()
+	if !ok && r.global != nil {
+		r.global.RefundN(1)
 	}
 
-	return lim.Allow()
+	return release, ok
+}
+
+// Acquire blocks until a slot from key's limiter is available or ctx is done,
+// and returns the function that releases it. If the limiter does not
+// implement Acquirer, Acquire waits like Wait and the release function does
+// nothing. With WithGlobal it first waits for a request from the global
+// limiter, as WaitN does.
+func (r *Registry) Acquire(ctx context.Context, key Identifier) (func(), error) {
+	lim := r.get(key)
+
+	a, ok := lim.(Acquirer)
+	if !ok {
+		if err := r.WaitN(ctx, key, 1); err != nil {
+			return nil, err
+		}
+
+		return func() {}, nil
+	}
+
+	var release func()
+
+	err := r.withGlobal(ctx, 1, func() error {
+		var err error
+
+		release, err = a.Acquire(ctx)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// withGlobal waits for n requests from the global limiter, if any, then calls
+// f, refunding the requests if f fails. It returns ErrWaitNotSupported if the
+// global limiter does not implement Waiter.
+func (r *Registry) withGlobal(ctx context.Context, n uint32, f func() error) error {
+	if r.global == nil {
+		return f()
+	}
+
+	w, ok := r.global.(Waiter)
+	if !ok {
+		return ErrWaitNotSupported
+	}
+
+	if err := w.WaitN(ctx, n); err != nil {
+		return err
+	}
+
+	if err := f(); err != nil {
+		r.global.RefundN(n)
+
+		return err
+	}
+
+	return nil
+}
+
+// get returns key's limiter, creating it if needed, and marks the key as used.
+func (r *Registry) get(key Identifier) Limiter {
+	s := r.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.get(r, key)
+}
+
+// newLimiter builds the limiter for a new key, from its override if it has one.
+func (r *Registry) newLimiter(key Identifier) Limiter {
+	if factory, ok := r.overrides[key]; ok {
+		return factory()
+	}
+
+	return r.factory(key)
+}
+
+func (r *Registry) shard(key Identifier) *shard {
+	return r.shards[maphash.String(r.seed, string(key))%uint64(len(r.shards))]
 }
@@ -1,13 +1,20 @@
 package registry_test
 
 import (
+	"context"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/serroba/rate"
 	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/concurrency"
+	"github.com/serroba/rate/ratetest"
 	"github.com/serroba/rate/registry"
+	"github.com/serroba/rate/store"
 	"github.com/serroba/rate/window"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -79,6 +86,20 @@ func (s SlidingWindowConfig) Build() registry.LimiterFactory {
 	}
 }
 
+// SlidingCounterConfig configures a sliding window counter rate limiter.
+type SlidingCounterConfig struct {
+	limit  uint32
+	window time.Duration
+}
+
+func (c SlidingCounterConfig) Name() string { return "sliding window counter" }
+
+func (c SlidingCounterConfig) Build() registry.LimiterFactory {
+	return func() registry.Limiter {
+		return window.NewSlidingCounterLimiter(c.limit, c.window)
+	}
+}
+
 // GCRAConfig configures a GCRA (Generic Cell Rate Algorithm) rate limiter.
 type GCRAConfig struct {
 	rate  float64
@@ -106,6 +127,7 @@ func allStrategies(capacity uint32, rate uint32, win time.Duration) []StrategyCo
 		LeakyBucketConfig{capacity: capacity, rate: rate},
 		FixedWindowConfig{limit: capacity, window: win},
 		SlidingWindowConfig{limit: capacity, duration: win},
+		SlidingCounterConfig{limit: capacity, window: win},
 		GCRAConfig{rate: gcraRate, burst: capacity},
 	}
 }
@@ -311,3 +333,697 @@ func TestRegistry_Allow_ConcurrentNewUsers(t *testing.T) {
 		})
 	}
 }
+
+func TestRegistry_AllowN(t *testing.T) {
+	t.Parallel()
+
+	strategies := allStrategies(10, 0, time.Hour)
+	for _, s := range strategies {
+		t.Run(s.Name(), func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := registry.NewRegistry(s.Build())
+			require.NoError(t, err)
+
+			require.True(t, reg.AllowN("alice", 7))
+			require.False(t, reg.AllowN("alice", 4))
+			require.True(t, reg.AllowN("alice", 3))
+			require.False(t, reg.Allow("alice"))
+
+			// Other keys are unaffected
+			require.True(t, reg.AllowN("bob", 10))
+		})
+	}
+}
+
+// unitLimiter only implements Allow, not AllowN.
+type unitLimiter struct {
+	left int
+}
+
+func (l *unitLimiter) Allow() bool {
+	if l.left == 0 {
+		return false
+	}
+
+	l.left--
+
+	return true
+}
+
+func TestRegistry_AllowN_WithoutNLimiter(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return &unitLimiter{left: 2}
+	})
+	require.NoError(t, err)
+
+	require.True(t, reg.AllowN("alice", 0))
+	require.False(t, reg.AllowN("alice", 2))
+	require.True(t, reg.AllowN("alice", 1))
+	require.True(t, reg.AllowN("alice", 1))
+	require.False(t, reg.AllowN("alice", 1))
+}
+
+func TestRegistry_Wait(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 100)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, reg.Wait(t.Context(), "alice"))
+	require.NoError(t, reg.Wait(t.Context(), "alice"))
+	require.NoError(t, reg.WaitN(t.Context(), "bob", 1))
+	require.ErrorIs(t, reg.WaitN(t.Context(), "bob", 2), bucket.ErrExceedsBurst)
+}
+
+func TestRegistry_Wait_NotSupported(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, reg.Wait(t.Context(), "alice"), registry.ErrWaitNotSupported)
+}
+
+func TestRegistry_Decide(t *testing.T) {
+	t.Parallel()
+
+	strategies := allStrategies(2, 0, time.Hour)
+	for _, s := range strategies {
+		t.Run(s.Name(), func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := registry.NewRegistry(s.Build())
+			require.NoError(t, err)
+
+			d := reg.Decide("alice")
+			require.True(t, d.Allowed)
+			require.Equal(t, uint32(2), d.Limit)
+			require.Equal(t, uint32(1), d.Remaining)
+
+			require.True(t, reg.DecideN("alice", 1).Allowed)
+			require.False(t, reg.Decide("alice").Allowed)
+		})
+	}
+}
+
+func TestRegistry_Decide_WithoutDecider(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, rate.Decision{Allowed: true}, reg.Decide("alice"))
+	require.Equal(t, rate.Decision{}, reg.Decide("alice"))
+}
+
+func TestRegistry_TryAcquire(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	})
+	require.NoError(t, err)
+
+	release, ok := reg.TryAcquire("alice")
+	require.True(t, ok)
+
+	_, ok = reg.TryAcquire("alice")
+	require.False(t, ok)
+
+	// Other keys have their own slots
+	_, ok = reg.TryAcquire("bob")
+	require.True(t, ok)
+
+	release()
+
+	_, ok = reg.TryAcquire("alice")
+	require.True(t, ok)
+}
+
+func TestRegistry_TryAcquire_RateLimiter(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	})
+	require.NoError(t, err)
+
+	// Rate limiters consume on acquire and have nothing to release
+	release, ok := reg.TryAcquire("alice")
+	require.True(t, ok)
+	release()
+
+	_, ok = reg.TryAcquire("alice")
+	require.False(t, ok)
+}
+
+func TestRegistry_Allow_Acquirer(t *testing.T) {
+	t.Parallel()
+
+	lim := concurrency.NewLimiter(1)
+
+	reg, err := registry.New(func() registry.Limiter { return lim })
+	require.NoError(t, err)
+
+	// Allowing does not hold a slot, which nothing would release
+	for range 3 {
+		require.True(t, reg.Allow("alice"))
+		require.True(t, reg.Decide("alice").Allowed)
+	}
+
+	require.Zero(t, lim.InFlight())
+	require.True(t, reg.AllowN("alice", 0))
+	require.False(t, reg.AllowN("alice", 2))
+
+	release, ok := reg.TryAcquire("alice")
+	require.True(t, ok)
+	require.False(t, reg.Allow("alice"))
+
+	release()
+	require.True(t, reg.Allow("alice"))
+	require.Zero(t, lim.InFlight())
+}
+
+func TestRegistry_Acquire(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	})
+	require.NoError(t, err)
+
+	release, err := reg.Acquire(t.Context(), "alice")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = reg.Acquire(ctx, "alice")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+
+	release, err = reg.Acquire(t.Context(), "alice")
+	require.NoError(t, err)
+	release()
+}
+
+func TestRegistry_Acquire_RateLimiter(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.NewRegistry(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 100)
+	})
+	require.NoError(t, err)
+
+	release, err := reg.Acquire(t.Context(), "alice")
+	require.NoError(t, err)
+	release()
+
+	// Waits for the refill
+	_, err = reg.Acquire(t.Context(), "alice")
+	require.NoError(t, err)
+
+	reg, err = registry.NewRegistry(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	})
+	require.NoError(t, err)
+
+	_, err = reg.Acquire(t.Context(), "alice")
+	require.ErrorIs(t, err, registry.ErrWaitNotSupported)
+
+	_, err = reg.Acquire(t.Context(), "alice")
+	require.ErrorIs(t, err, registry.ErrWaitNotSupported)
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	factory := func() registry.Limiter { return &unitLimiter{left: 1} }
+
+	_, err := registry.New(factory, registry.WithIdleTTL(-time.Second))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+
+	_, err = registry.New(factory, registry.WithClock(nil))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+
+	_, err = registry.New(factory, registry.WithMaxKeys(-1))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+
+	_, err = registry.New(factory, registry.WithShards(0))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+}
+
+func TestRegistry_IdleTTL(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	var created atomic.Int32
+
+	reg, err := registry.New(func() registry.Limiter {
+		created.Add(1)
+
+		return &unitLimiter{left: 1}
+	}, registry.WithClock(clock), registry.WithIdleTTL(time.Minute), registry.WithKeys("alice"))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), created.Load())
+
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+
+	// Still in use
+	clock.Advance(50 * time.Second)
+	require.False(t, reg.Allow("alice"))
+
+	// bob has been idle for the TTL and is evicted, alice is not
+	clock.Advance(20 * time.Second)
+	require.True(t, reg.Allow("bob"))
+	require.False(t, reg.Allow("alice"))
+	require.Equal(t, int32(3), created.Load())
+}
+
+func TestRegistry_IdleTTL_WaitsForRecovery(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	for _, factory := range []registry.LimiterFactory{
+		// Full again after 10 seconds
+		func() registry.Limiter { return bucket.NewLimiterWithClock(10, 1, clock) },
+		func() registry.Limiter { return bucket.NewGCRALimiterWithClock(1, 10, clock) },
+		func() registry.Limiter { return window.NewSlidingLimiterWithClock(10, 10*time.Second, clock) },
+	} {
+		reg, err := registry.New(factory, registry.WithClock(clock), registry.WithIdleTTL(time.Second))
+		require.NoError(t, err)
+
+		require.True(t, reg.AllowN("alice", 10))
+
+		// Idle for longer than the TTL but only half recovered: evicting
+		// alice would hand out a fresh burst
+		clock.Advance(5 * time.Second)
+		require.False(t, reg.AllowN("alice", 10))
+
+		clock.Advance(11 * time.Second)
+		require.True(t, reg.AllowN("alice", 10))
+	}
+}
+
+func TestRegistry_IdleTTL_KeepsHeldSlots(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	reg, err := registry.New(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	}, registry.WithClock(clock), registry.WithIdleTTL(time.Minute))
+	require.NoError(t, err)
+
+	release, ok := reg.TryAcquire("alice")
+	require.True(t, ok)
+
+	clock.Advance(time.Hour)
+	_, ok = reg.TryAcquire("bob")
+	require.True(t, ok)
+
+	_, ok = reg.TryAcquire("alice")
+	require.False(t, ok)
+
+	release()
+}
+
+func TestRegistry_MaxKeys(t *testing.T) {
+	t.Parallel()
+
+	var evicted []registry.Identifier
+
+	reg, err := registry.New(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	}, registry.WithShards(1), registry.WithMaxKeys(2), registry.WithOnEvict(func(key registry.Identifier, _ registry.Limiter) {
+		evicted = append(evicted, key)
+	}))
+	require.NoError(t, err)
+
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("bob"))
+	require.False(t, reg.Allow("alice"))
+
+	// bob is the least recently used key
+	require.True(t, reg.Allow("carol"))
+	require.Equal(t, []registry.Identifier{"bob"}, evicted)
+	require.False(t, reg.Allow("alice"))
+
+	// Now carol is, and bob comes back with a fresh limiter
+	require.True(t, reg.Allow("bob"))
+	require.Equal(t, []registry.Identifier{"bob", "carol"}, evicted)
+	require.Equal(t, registry.Stats{Evicted: 2}, reg.Stats())
+}
+
+func TestRegistry_Stats_Expired(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	var evicted []registry.Identifier
+
+	reg, err := registry.New(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	}, registry.WithClock(clock), registry.WithIdleTTL(time.Minute), registry.WithShards(1),
+		registry.WithOnEvict(func(key registry.Identifier, _ registry.Limiter) {
+			evicted = append(evicted, key)
+		}))
+	require.NoError(t, err)
+
+	require.True(t, reg.Allow("alice"))
+	clock.Advance(30 * time.Second)
+	require.True(t, reg.Allow("bob"))
+	clock.Advance(30 * time.Second)
+
+	// Only alice has been idle for the TTL
+	require.True(t, reg.Allow("carol"))
+	require.Equal(t, []registry.Identifier{"alice"}, evicted)
+	require.Equal(t, registry.Stats{Expired: 1}, reg.Stats())
+}
+
+func TestRegistry_MaxKeys_Sharded(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return &unitLimiter{left: 1}
+	}, registry.WithMaxKeys(5))
+	require.NoError(t, err)
+
+	for i := range 100 {
+		require.True(t, reg.Allow(registry.Identifier(strconv.Itoa(i))))
+	}
+
+	// The cap holds across shards
+	require.Equal(t, uint64(95), reg.Stats().Evicted)
+}
+
+// blockingLimiter blocks in Allow until unblock is closed.
+type blockingLimiter struct {
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (l *blockingLimiter) Allow() bool {
+	close(l.entered)
+	<-l.unblock
+
+	return true
+}
+
+func TestRegistry_Allow_LimiterCalledOutsideLock(t *testing.T) {
+	t.Parallel()
+
+	slow := &blockingLimiter{entered: make(chan struct{}), unblock: make(chan struct{})}
+	limiters := []registry.Limiter{slow, &unitLimiter{left: 1}}
+
+	reg, err := registry.New(func() registry.Limiter {
+		lim := limiters[0]
+		limiters = limiters[1:]
+
+		return lim
+	}, registry.WithShards(1), registry.WithKeys("slow"))
+	require.NoError(t, err)
+
+	done := make(chan bool)
+
+	go func() { done <- reg.Allow("slow") }()
+
+	<-slow.entered
+
+	// Other keys are not blocked by the slow limiter, even in the same shard
+	require.True(t, reg.Allow("fast"))
+
+	close(slow.unblock)
+	require.True(t, <-done)
+}
+
+func BenchmarkRegistry_Allow_Parallel(b *testing.B) {
+	keys := make([]registry.Identifier, 1024)
+	for i := range keys {
+		keys[i] = registry.Identifier("key-" + strconv.Itoa(i))
+	}
+
+	for _, shards := range []int{1, 32} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			reg, err := registry.New(func() registry.Limiter {
+				return bucket.NewGCRALimiter(1e9, 1000)
+			}, registry.WithShards(shards), registry.WithKeys(keys...))
+			require.NoError(b, err)
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					reg.Allow(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestNewKeyed(t *testing.T) {
+	t.Parallel()
+
+	// Enterprise customers get 10x the free limit
+	reg, err := registry.NewKeyed(func(key registry.Identifier) registry.Limiter {
+		if strings.HasPrefix(string(key), "ent-") {
+			return bucket.NewTokenLimiter(10, 0)
+		}
+
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithOverrides(map[registry.Identifier]registry.LimiterFactory{
+		"internal": func() registry.Limiter { return bucket.NewTokenLimiter(100, 0) },
+		"abuser":   func() registry.Limiter { return bucket.NewTokenLimiter(0, 0) },
+	}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		key  registry.Identifier
+		want uint32
+	}{
+		{key: "free-1", want: 1},
+		{key: "ent-1", want: 10},
+		{key: "internal", want: 100},
+		{key: "abuser", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.key), func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.want, reg.DecideN(tt.key, 0).Limit)
+		})
+	}
+}
+
+func TestWithOverrides(t *testing.T) {
+	t.Parallel()
+
+	factory := func() registry.Limiter { return bucket.NewTokenLimiter(1, 0) }
+	blocked := func() registry.Limiter { return bucket.NewTokenLimiter(0, 0) }
+
+	// Later overrides win
+	reg, err := registry.New(factory,
+		registry.WithOverrides(map[registry.Identifier]registry.LimiterFactory{"alice": blocked}),
+		registry.WithOverrides(map[registry.Identifier]registry.LimiterFactory{"alice": factory}),
+	)
+	require.NoError(t, err)
+	require.True(t, reg.Allow("alice"))
+
+	_, err = registry.New(factory, registry.WithOverrides(map[registry.Identifier]registry.LimiterFactory{
+		"alice": nil,
+	}))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+}
+
+func TestNewWithStore(t *testing.T) {
+	t.Parallel()
+
+	st := store.NewMemory()
+	factory := func() store.Algorithm { return bucket.NewTokenLimiter(3, 0) }
+
+	// Two instances sharing a store share the limits
+	first, err := registry.NewWithStore(st, factory)
+	require.NoError(t, err)
+
+	second, err := registry.NewWithStore(st, factory)
+	require.NoError(t, err)
+
+	require.True(t, first.AllowN("alice", 2))
+	require.False(t, second.AllowN("alice", 2))
+	require.True(t, second.Allow("alice"))
+
+	d := first.Decide("alice")
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+
+	// Evicting the handles keeps the state
+	evicting, err := registry.NewWithStore(st, factory, registry.WithShards(1), registry.WithMaxKeys(1))
+	require.NoError(t, err)
+
+	require.False(t, evicting.Allow("alice"))
+	require.True(t, evicting.Allow("bob"))
+	require.Equal(t, uint64(1), evicting.Stats().Evicted)
+	require.False(t, evicting.Allow("alice"))
+}
+
+func TestRegistry_WithGlobal(t *testing.T) {
+	t.Parallel()
+
+	global := bucket.NewTokenLimiter(3, 0)
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(2, 0)
+	}, registry.WithGlobal(global))
+	require.NoError(t, err)
+
+	require.True(t, reg.Allow("alice"))
+	require.True(t, reg.Allow("alice"))
+
+	// Denied for the key: the global limit is refunded
+	require.False(t, reg.Allow("alice"))
+	require.Equal(t, uint32(1), global.DecideN(0).Remaining)
+
+	// The global limit has fewer requests remaining than bob's
+	d := reg.Decide("bob")
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+	require.Zero(t, d.Remaining)
+
+	// Denied globally: bob's limit is untouched
+	d = reg.Decide("bob")
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+	require.False(t, reg.AllowN("carol", 1))
+
+	bob, ok := reg.Get("bob")
+	require.True(t, ok)
+	require.Equal(t, uint32(1), bob.(*bucket.TokenLimiter).DecideN(0).Remaining)
+}
+
+func TestRegistry_WithGlobal_KeyFails(t *testing.T) {
+	t.Parallel()
+
+	global := bucket.NewTokenLimiter(3, 0)
+
+	st := newFlakyStore()
+	st.down.Store(true)
+
+	reg, err := registry.NewWithStore(st, func() store.Algorithm {
+		return bucket.NewTokenLimiter(2, 0)
+	}, registry.WithGlobal(global))
+	require.NoError(t, err)
+
+	_, err = reg.DecideContext(context.Background(), "alice")
+	require.Error(t, err)
+	require.Equal(t, uint32(3), global.DecideN(0).Remaining)
+}
+
+func TestRegistry_WithGlobal_Wait(t *testing.T) {
+	t.Parallel()
+
+	global := bucket.NewTokenLimiter(2, 0)
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithGlobal(global))
+	require.NoError(t, err)
+
+	require.NoError(t, reg.Wait(t.Context(), "alice"))
+	require.Equal(t, uint32(1), global.DecideN(0).Remaining)
+
+	// The key's wait fails: the global limit is refunded
+	ctx, cancel := context.WithTimeout(t.Context(), time.Minute)
+	defer cancel()
+
+	require.ErrorIs(t, reg.Wait(ctx, "alice"), bucket.ErrWouldExceedDeadline)
+	require.Equal(t, uint32(1), global.DecideN(0).Remaining)
+
+	// The global wait fails: carol's limit is untouched
+	require.NoError(t, reg.Wait(t.Context(), "bob"))
+	require.ErrorIs(t, reg.Wait(ctx, "carol"), bucket.ErrWouldExceedDeadline)
+
+	_, err = reg.Acquire(ctx, "carol")
+	require.ErrorIs(t, err, bucket.ErrWouldExceedDeadline)
+
+	carol, ok := reg.Get("carol")
+	require.True(t, ok)
+	require.Equal(t, uint32(1), carol.(*bucket.TokenLimiter).DecideN(0).Remaining)
+}
+
+func TestRegistry_WithGlobal_Acquire(t *testing.T) {
+	t.Parallel()
+
+	global := bucket.NewTokenLimiter(2, 0)
+
+	reg, err := registry.New(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	}, registry.WithGlobal(global))
+	require.NoError(t, err)
+
+	release, ok := reg.TryAcquire("alice")
+	require.True(t, ok)
+
+	// No slot for the key: the global limit is refunded
+	_, ok = reg.TryAcquire("alice")
+	require.False(t, ok)
+	require.Equal(t, uint32(1), global.DecideN(0).Remaining)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = reg.Acquire(ctx, "alice")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, uint32(1), global.DecideN(0).Remaining)
+
+	// Releasing frees the slot but does not refund the global limit
+	release()
+
+	release, err = reg.Acquire(t.Context(), "alice")
+	require.NoError(t, err)
+	release()
+
+	_, ok = reg.TryAcquire("bob")
+	require.False(t, ok)
+}
+
+func TestRegistry_WithGlobal_WaitNotSupported(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithGlobal(unitRefunder{}))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, reg.Wait(t.Context(), "alice"), registry.ErrWaitNotSupported)
+	require.True(t, reg.Allow("alice"))
+}
+
+// unitRefunder is a global limiter that allows everything and cannot wait.
+type unitRefunder struct{}
+
+func (unitRefunder) Allow() bool { return true }
+
+func (unitRefunder) RefundN(uint32) {}
+
+func TestWithGlobal_Nil(t *testing.T) {
+	t.Parallel()
+
+	_, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 0)
+	}, registry.WithGlobal(nil))
+	require.ErrorIs(t, err, registry.ErrInvalidOption)
+}
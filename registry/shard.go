@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultShards is the number of shards a registry is split into unless
+// WithShards says otherwise.
+const defaultShards = 32
+
+// shard is one hash partition of a registry's keys with its own lock, LRU
+// list and idle sweep schedule.
+type shard struct {
+	mu        sync.Mutex
+	limiters  map[Identifier]*entry
+	lru       *list.List // Entries, most recently used first
+	nextSweep time.Time
+	maxKeys   int
+	stats     Stats
+}
+
+type entry struct {
+	key      Identifier
+	lim      Limiter
+	fallback Limiter // For FailLocal, created on the first failure
+	lastSeen time.Time
+	elem     *list.Element
+}
+
+func newShard(maxKeys int, nextSweep time.Time) *shard {
+	return &shard{
+		limiters:  make(map[Identifier]*entry),
+		lru:       list.New(),
+		nextSweep: nextSweep,
+		maxKeys:   maxKeys,
+	}
+}
+
+// get returns key's limiter, creating it if needed, and marks the key as used.
+// The caller must hold s.mu.
+func (s *shard) get(r *Registry, key Identifier) Limiter {
+	now := r.clock.Now()
+
+	if r.idleTTL > 0 && !now.Before(s.nextSweep) {
+		s.sweep(r, now)
+		s.nextSweep = now.Add(r.idleTTL)
+	}
+
+	e, ok := s.limiters[key]
+	if !ok {
+		return s.insert(r, key, now).lim
+	}
+
+	e.lastSeen = now
+	s.lru.MoveToFront(e.elem)
+
+	return e.lim
+}
+
+// insert adds a new limiter for key, first evicting the least recently used
+// key if the shard is full. The caller must hold s.mu.
+func (s *shard) insert(r *Registry, key Identifier, now time.Time) *entry {
+	if s.maxKeys > 0 && len(s.limiters) >= s.maxKeys {
+		s.remove(r, s.lru.Back().Value.(*entry))
+		s.stats.Evicted++
+	}
+
+	e := &entry{key: key, lim: r.newLimiter(key), lastSeen: now}
+	e.elem = s.lru.PushFront(e)
+	s.limiters[key] = e
+
+	return e
+}
+
+// sweep evicts keys that have been idle for the TTL and whose limiter has
+// fully recovered. The caller must hold s.mu.
+func (s *shard) sweep(r *Registry, now time.Time) {
+	// Walk from the least recently used key and stop at the first one that
+	// is not idle: all keys before it in the list were used even later.
+	for elem := s.lru.Back(); elem != nil; {
+		e := elem.Value.(*entry)
+		if now.Sub(e.lastSeen) < r.idleTTL {
+			return
+		}
+
+		elem = elem.Prev()
+
+		if recovered(e.lim, now) {
+			s.remove(r, e)
+			s.stats.Expired++
+		}
+	}
+}
+
+// remove drops e from the shard and reports it to the eviction callback.
+// The caller must hold s.mu.
+func (s *shard) remove(r *Registry, e *entry) {
+	delete(s.limiters, e.key)
+	s.lru.Remove(e.elem)
+
+	if r.onEvict != nil {
+		r.onEvict(e.key, e.lim)
+	}
+}
+
+func recovered(lim Limiter, now time.Time) bool {
+	rec, ok := lim.(Recoverer)
+	if !ok {
+		return true
+	}
+
+	t, ok := rec.RecoveredAt()
+
+	return ok && !now.Before(t)
+}
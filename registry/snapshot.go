@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// ErrInvalidInterval is returned by Checkpoint when the interval is not
+// positive.
+var ErrInvalidInterval = errors.New("registry: invalid checkpoint interval")
+
+// record is a key's limiter state in a snapshot. A snapshot is a stream of
+// records, one JSON object per line.
+type record struct {
+	Key   Identifier      `json:"key"`
+	State json.RawMessage `json:"state"`
+}
+
+// Snapshot writes the state of every limiter that implements json.Marshaler
+// to w, so it can be restored with Restore, e.g. after a restart. Limiters
+// without such state, like concurrency.Limiter, are left out, as are those
+// keeping their state elsewhere, like the limiters of NewWithStore. Keys are
+// collected as by Range, so a snapshot taken while the registry is in use is
+// consistent per key but not across keys.
+func (r *Registry) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	for key, lim := range r.Range {
+		m, ok := lim.(json.Marshaler)
+		if !ok {
+			continue
+		}
+
+		state, err := m.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("registry: snapshot %q: %w", key, err)
+		}
+
+		if err := enc.Encode(record{Key: key, State: state}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore reads a snapshot written by Snapshot and restores each key's state
+// into its limiter, creating the limiter with the registry's factory if
+// needed. The limiters keep the configuration the factory gives them, so
+// limits changed since the snapshot apply to the restored state. Limiters that
+// don't implement json.Unmarshaler are created but not restored. It stops at
+// the first key whose state doesn't fit its limiter, e.g. after switching
+// algorithms, and returns the error.
+func (r *Registry) Restore(rd io.Reader) error {
+	dec := json.NewDecoder(rd)
+
+	for {
+		var rec record
+
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		u, ok := r.get(rec.Key).(json.Unmarshaler)
+		if !ok {
+			continue
+		}
+
+		if err := u.UnmarshalJSON(rec.State); err != nil {
+			return fmt.Errorf("registry: restore %q: %w", rec.Key, err)
+		}
+	}
+}
+
+// SnapshotFile writes a snapshot to path. It is written to a temporary file
+// in the same directory first, synced to disk and then renamed over path, so
+// path always holds a complete snapshot, even after a crash.
+func (r *Registry) SnapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	if err := r.Snapshot(f); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	// Flush to disk before the rename, so a crash cannot leave path empty.
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Checkpoint writes a snapshot to path with SnapshotFile every interval until
+// ctx is done, and then writes a last one and returns. It returns early with
+// the error if a snapshot fails, and with ErrInvalidInterval, without
+// writing any, if interval is not positive.
+func (r *Registry) Checkpoint(ctx context.Context, path string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidInterval, interval)
+	}
+
+	for {
+		if err := clock.Sleep(ctx, r.clock, interval); err != nil {
+			return r.SnapshotFile(path)
+		}
+
+		if err := r.SnapshotFile(path); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package registry_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/concurrency"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/registry"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	factories := map[string]registry.LimiterFactory{
+		"token":   func() registry.Limiter { return bucket.NewLimiterWithClock(3, 0, clock) },
+		"leaky":   func() registry.Limiter { return bucket.NewLeakyLimiterWithClock(3, 0, clock) },
+		"gcra":    func() registry.Limiter { return bucket.NewGCRALimiterWithClock(0.001, 3, clock) },
+		"atomic":  func() registry.Limiter { return bucket.NewAtomicGCRALimiterWithClock(0.001, 3, clock) },
+		"fixed":   func() registry.Limiter { return window.NewFixedLimiterWithClock(3, time.Hour, clock) },
+		"sliding": func() registry.Limiter { return window.NewSlidingLimiterWithClock(3, time.Hour, clock) },
+		"counter": func() registry.Limiter { return window.NewSlidingCounterLimiterWithClock(3, time.Hour, clock) },
+	}
+
+	for name, factory := range factories {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reg, err := registry.New(factory, registry.WithClock(clock))
+			require.NoError(t, err)
+
+			require.True(t, reg.AllowN("alice", 3))
+			require.True(t, reg.AllowN("bob", 1))
+
+			var buf bytes.Buffer
+			require.NoError(t, reg.Snapshot(&buf))
+
+			// A restarted registry carries on where the old one stopped
+			restored, err := registry.New(factory, registry.WithClock(clock))
+			require.NoError(t, err)
+			require.NoError(t, restored.Restore(&buf))
+
+			require.False(t, restored.Allow("alice"))
+			require.False(t, restored.AllowN("bob", 3))
+			require.True(t, restored.AllowN("bob", 2))
+			require.True(t, restored.AllowN("carol", 3))
+		})
+	}
+}
+
+func TestRegistry_Snapshot_SkipsStatelessLimiters(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return concurrency.NewLimiter(1)
+	}, registry.WithKeys("alice"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, reg.Snapshot(&buf))
+	require.Zero(t, buf.Len())
+
+	// Records for limiters without state are ignored
+	require.NoError(t, reg.Restore(strings.NewReader(`{"key":"alice","state":{"tokens":1}}`)))
+}
+
+func TestRegistry_Restore_Errors(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 1)
+	})
+	require.NoError(t, err)
+
+	require.Error(t, reg.Restore(strings.NewReader(`{"key":`)))
+
+	// State of another algorithm
+	err = reg.Restore(strings.NewReader(`{"key":"alice","state":{"tat":"2024-01-01T12:00:00Z"}}`))
+	require.ErrorContains(t, err, `"alice"`)
+}
+
+func TestRegistry_Checkpoint(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "limits.json")
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewLimiterWithClock(3, 0, clock)
+	}, registry.WithClock(clock))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+
+	go func() { done <- reg.Checkpoint(ctx, path, time.Minute) }()
+
+	require.True(t, reg.AllowN("alice", 3))
+	clock.BlockUntil(1)
+	require.NoFileExists(t, path)
+
+	// Wait for the checkpoint to go back to sleep
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	require.FileExists(t, path)
+
+	// A last snapshot is written on shutdown
+	require.True(t, reg.AllowN("bob", 3))
+	cancel()
+	require.NoError(t, <-done)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+
+	defer f.Close()
+
+	restored, err := registry.New(func() registry.Limiter {
+		return bucket.NewLimiterWithClock(3, 0, clock)
+	}, registry.WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, restored.Restore(f))
+	require.False(t, restored.Allow("alice"))
+	require.False(t, restored.Allow("bob"))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestRegistry_SnapshotFile_Error(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 1)
+	})
+	require.NoError(t, err)
+
+	require.Error(t, reg.SnapshotFile(filepath.Join(t.TempDir(), "missing", "limits.json")))
+	require.Error(t, reg.Checkpoint(t.Context(), filepath.Join(t.TempDir(), "missing", "limits.json"), time.Millisecond))
+}
+
+func TestRegistry_Checkpoint_InvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 1)
+	})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+
+	require.ErrorIs(t, reg.Checkpoint(t.Context(), path, 0), registry.ErrInvalidInterval)
+	require.ErrorIs(t, reg.Checkpoint(t.Context(), path, -time.Second), registry.ErrInvalidInterval)
+	require.NoFileExists(t, path)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestRegistry_Snapshot_WriteError(t *testing.T) {
+	t.Parallel()
+
+	reg, err := registry.New(func() registry.Limiter {
+		return bucket.NewTokenLimiter(1, 1)
+	}, registry.WithKeys("alice"))
+	require.NoError(t, err)
+
+	require.ErrorIs(t, reg.Snapshot(failingWriter{}), os.ErrClosed)
+}
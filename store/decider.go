@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+
+	"github.com/serroba/rate"
+)
+
+// Decider provides the Allow, AllowN, Decide and DecideN methods of a limiter
+// whose decisions can fail, such as one keeping its state on a server, on top
+// of its DecideNContext method. They decide with context.Background and deny
+// when that fails, returning a zero Decision; use DecideNContext to tell
+// failures apart.
+//
+// Limiters embed it and set it with NewDecider, as the limiters of this
+// package and of the redis and memcached packages do.
+type Decider struct {
+	decide func(ctx context.Context, n uint32) (rate.Decision, error)
+}
+
+// NewDecider returns a Decider making its decisions with decide, typically
+// the limiter's DecideNContext method.
+func NewDecider(decide func(ctx context.Context, n uint32) (rate.Decision, error)) Decider {
+	return Decider{decide: decide}
+}
+
+// Allow reports whether a request is allowed.
+func (d Decider) Allow() bool {
+	return d.AllowN(1)
+}
+
+// AllowN reports whether n requests are allowed at once.
+func (d Decider) AllowN(n uint32) bool {
+	return d.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the limiter state.
+func (d Decider) Decide() rate.Decision {
+	return d.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the limiter state.
+func (d Decider) DecideN(n uint32) rate.Decision {
+	dec, err := d.decide(context.Background(), n)
+	if err != nil {
+		return rate.Decision{}
+	}
+
+	return dec
+}
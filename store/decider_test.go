@@ -0,0 +1,32 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecider(t *testing.T) {
+	t.Parallel()
+
+	var got []uint32
+
+	d := store.NewDecider(func(_ context.Context, n uint32) (rate.Decision, error) {
+		got = append(got, n)
+		if n > 2 {
+			return rate.Decision{Allowed: true, Limit: 5}, errTest
+		}
+
+		return rate.Decision{Allowed: true, Limit: 5, Remaining: 5 - n}, nil
+	})
+
+	require.True(t, d.Allow())
+	require.True(t, d.AllowN(2))
+	require.Equal(t, rate.Decision{Allowed: true, Limit: 5, Remaining: 4}, d.Decide())
+	require.Equal(t, rate.Decision{}, d.DecideN(3), "failures deny")
+	require.False(t, d.AllowN(3))
+	require.Equal(t, []uint32{1, 2, 1, 3, 3}, got)
+}
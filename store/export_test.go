@@ -0,0 +1,19 @@
+package store
+
+// Statements returns the statements a SQL store runs against table in
+// dialect, by name, so that tests can check dialects they cannot run.
+func Statements(dialect Dialect, table string) (map[string]string, error) {
+	q, err := newQueries(dialect, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"create":        q.create,
+		"insert":        q.insert,
+		"lock":          q.lock,
+		"update":        q.update,
+		"delete":        q.delete,
+		"deleteExpired": q.deleteExpired,
+	}, nil
+}
@@ -0,0 +1,258 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+)
+
+// defaultLeaseTTL is how long a lease lasts if no TTL is given.
+const defaultLeaseTTL = time.Second
+
+// LeasingLimiter is a token bucket shared through a Store that takes tokens
+// from the store in batches, called leases, and spends them locally. Only
+// requests that find the lease used up or expired go to the store, so most
+// requests are decided without a round trip.
+//
+// Tokens are taken from the shared bucket before they are spent, so the
+// instances together never admit more than the bucket allows. The price is
+// accuracy the other way: tokens leased by one instance cannot be spent by
+// another, which may be denied early. Unused tokens are given back when the
+// lease expires, at the next request after that, or on Release, so at most
+// the maximum lease size per instance is held back.
+//
+// Lease sizes adapt to traffic: a lease used up before it expires is followed
+// by one twice as large, up to the maximum, and a lease that expires is
+// followed by one the size of what was used of it.
+//
+// The shared state has its own format, so all instances limiting a key must
+// use a LeasingLimiter with the same capacity and rate.
+type LeasingLimiter struct {
+	Decider
+
+	store          Store
+	key            string
+	capacity, rate float64
+	maxLease       uint32
+	ttl            time.Duration
+	clock          clock.Clock
+
+	mu        sync.Mutex
+	tokens    uint32 // Left of the current lease
+	used      uint32 // Spent of the current lease
+	size      uint32 // Of the next lease
+	expiresAt time.Time
+	resetAt   time.Time // When the shared bucket was last seen to be full
+}
+
+// leaseState is the shared bucket in the store.
+type leaseState struct {
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+}
+
+// NewLeasingLimiter creates a leasing limiter for key in s. Capacity and rate
+// configure the shared bucket as in bucket.NewTokenLimiter. maxLease bounds
+// how many tokens are leased at once, defaulting to the capacity, and ttl is
+// how long a lease may be spent, defaulting to a second.
+func NewLeasingLimiter(s Store, key string, capacity, rate, maxLease uint32, ttl time.Duration) *LeasingLimiter {
+	return NewLeasingLimiterWithClock(s, key, capacity, rate, maxLease, ttl, clock.Real{})
+}
+
+// NewLeasingLimiterWithClock creates a leasing limiter with a custom clock.
+// Use this constructor for testing with a mock clock.
+func NewLeasingLimiterWithClock(
+	s Store, key string, capacity, rate, maxLease uint32, ttl time.Duration, clock clock.Clock,
+) *LeasingLimiter {
+	if maxLease == 0 || maxLease > capacity {
+		maxLease = max(1, capacity)
+	}
+
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+
+	l := &LeasingLimiter{
+		store:    s,
+		key:      key,
+		capacity: float64(capacity),
+		rate:     float64(rate),
+		maxLease: maxLease,
+		ttl:      ttl,
+		clock:    clock,
+		size:     1,
+	}
+	l.Decider = NewDecider(l.DecideNContext)
+
+	return l
+}
+
+// DecideNContext reports whether n requests are allowed at once, and the
+// limiter state: Remaining is the number of tokens left of the lease and
+// ResetAt is when the shared bucket would be full if no more tokens were
+// taken, as of the last lease. It passes ctx to the store when it renews the
+// lease and returns its error, if any.
+func (l *LeasingLimiter) DecideNContext(ctx context.Context, n uint32) (rate.Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	d := rate.Decision{Limit: uint32(l.capacity)}
+
+	switch {
+	case float64(n) > l.capacity:
+		// Never allowed, as bucket.ErrExceedsBurst.
+	case n <= l.tokens && now.Before(l.expiresAt):
+		l.spend(n)
+
+		d.Allowed = true
+	default:
+		l.adapt(now, n)
+
+		retry, err := l.renew(ctx, now, n)
+		if err != nil {
+			return rate.Decision{}, err
+		}
+
+		d.Allowed = retry == 0
+		if d.Allowed {
+			l.spend(n)
+		} else if retry != clock.Forever {
+			d.RetryAfter = retry
+		}
+	}
+
+	d.Remaining = l.tokens
+	d.ResetAt = l.resetAt
+
+	return d, nil
+}
+
+// adapt sizes the next lease, which is about to be taken for n tokens, by how
+// the current one was used. The caller must hold l.mu.
+func (l *LeasingLimiter) adapt(now time.Time, n uint32) {
+	if !now.Before(l.expiresAt) {
+		l.size = max(1, l.used)
+	} else if n > l.tokens {
+		l.size = min(l.maxLease, l.size*2)
+	}
+}
+
+// spend takes n tokens from the lease. The caller must hold l.mu.
+func (l *LeasingLimiter) spend(n uint32) {
+	l.tokens -= n
+	l.used += n
+}
+
+// renew gives back what is left of the lease and takes a new one of at least
+// n tokens, in one update. If fewer than n tokens are available it takes none
+// and returns the time until n will be. The caller must hold l.mu.
+func (l *LeasingLimiter) renew(ctx context.Context, now time.Time, n uint32) (time.Duration, error) {
+	granted, retry, err := l.exchange(ctx, now, l.tokens, n, max(n, l.size))
+	if err != nil {
+		return 0, err
+	}
+
+	l.tokens, l.used = granted, 0
+	l.expiresAt = now.Add(l.ttl)
+
+	return retry, nil
+}
+
+// Release gives back the tokens left of the lease, e.g. when shutting down,
+// so that other instances can spend them. The limiter remains usable.
+func (l *LeasingLimiter) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens == 0 {
+		return nil
+	}
+
+	if _, _, err := l.exchange(ctx, l.clock.Now(), l.tokens, 0, 0); err != nil {
+		return err
+	}
+
+	l.tokens, l.used = 0, 0
+
+	return nil
+}
+
+// exchange returns tokens to the shared bucket and, if at least need tokens
+// are then available, takes up to want of them. It returns how many it took,
+// or the time until need tokens will be available. The caller must hold l.mu.
+func (l *LeasingLimiter) exchange(
+	ctx context.Context, now time.Time, returned, need, want uint32,
+) (uint32, time.Duration, error) {
+	var (
+		granted uint32
+		retry   time.Duration
+	)
+
+	err := l.store.Update(ctx, l.key, func(state []byte) ([]byte, time.Time, error) {
+		st := leaseState{Tokens: l.capacity, Last: now}
+		if state != nil {
+			if err := json.Unmarshal(state, &st); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+
+		if now.After(st.Last) {
+			st.Tokens = min(l.capacity, st.Tokens+now.Sub(st.Last).Seconds()*l.rate)
+			st.Last = now
+		}
+
+		st.Tokens = min(l.capacity, st.Tokens+float64(returned))
+
+		granted, retry = 0, 0
+
+		switch {
+		case need == 0:
+			// Only giving back
+		case st.Tokens >= float64(need):
+			granted = uint32(min(float64(want), math.Floor(st.Tokens)))
+			st.Tokens -= float64(granted)
+		case l.rate > 0:
+			retry = clock.Seconds((float64(need) - st.Tokens) / l.rate)
+		default:
+			retry = clock.Forever
+		}
+
+		switch {
+		case st.Tokens >= l.capacity:
+			// Nothing to remember
+			l.resetAt = st.Last
+
+			return nil, time.Time{}, nil
+		case l.rate > 0:
+			l.resetAt = st.Last.Add(clock.Seconds((l.capacity - st.Tokens) / l.rate))
+		default:
+			l.resetAt = time.Time{}
+		}
+
+		next, err := json.Marshal(st)
+
+		return next, l.resetAt, err
+	})
+
+	return granted, retry, err
+}
+
+// RecoveredAt returns when the lease expires, after which the limiter holds
+// nothing that another instance could use. A registry evicting it earlier
+// would strand the tokens left of the lease until the shared bucket refills.
+func (l *LeasingLimiter) RecoveredAt() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens == 0 {
+		return time.Time{}, true
+	}
+
+	return l.expiresAt, true
+}
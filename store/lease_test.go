@@ -0,0 +1,203 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the updates of a Store.
+type countingStore struct {
+	store.Store
+
+	updates int
+}
+
+func (s *countingStore) Update(
+	ctx context.Context, key string, fn func([]byte) ([]byte, time.Time, error),
+) error {
+	s.updates++
+
+	return s.Store.Update(ctx, key, fn)
+}
+
+// sharedTokens returns the tokens left in key's shared bucket in s.
+func sharedTokens(t *testing.T, s store.Store, key string) float64 {
+	t.Helper()
+
+	state := get(t, s, key)
+	if state == "" {
+		return -1
+	}
+
+	var st struct {
+		Tokens float64 `json:"tokens"`
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(state), &st))
+
+	return st.Tokens
+}
+
+func TestLeasingLimiter_Batches(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := &countingStore{Store: store.NewMemoryWithClock(clock)}
+	lim := store.NewLeasingLimiterWithClock(st, "alice", 100, 0, 8, time.Minute, clock)
+
+	for range 100 {
+		require.True(t, lim.Allow())
+	}
+
+	// Leases of 1, 2, 4 and then 8 tokens.
+	require.Equal(t, 3+12, st.updates)
+
+	d := lim.Decide()
+	require.False(t, d.Allowed)
+	require.Equal(t, rate.Decision{Limit: 100}, d)
+}
+
+func TestLeasingLimiter_AdaptsLeaseSize(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := store.NewMemoryWithClock(clock)
+	lim := store.NewLeasingLimiterWithClock(st, "alice", 100, 0, 0, time.Second, clock)
+
+	remaining := func() uint32 {
+		d := lim.Decide()
+		require.True(t, d.Allowed)
+
+		return d.Remaining
+	}
+
+	// Used up leases double.
+	require.Zero(t, remaining())
+	require.Equal(t, uint32(1), remaining())
+	require.Zero(t, remaining())
+	require.Equal(t, uint32(3), remaining())
+	require.InDelta(t, 100-7, sharedTokens(t, st, "alice"), 0)
+
+	// An expired lease is given back and followed by one of what was used.
+	clock.Advance(time.Second)
+	require.Equal(t, uint32(0), remaining())
+	require.InDelta(t, 100-5, sharedTokens(t, st, "alice"), 0)
+
+	// A lease doesn't outgrow the capacity.
+	big := store.NewLeasingLimiterWithClock(st, "bob", 3, 0, 10, time.Minute, clock)
+	for range 3 {
+		require.True(t, big.Allow())
+	}
+
+	require.InDelta(t, 0, sharedTokens(t, st, "bob"), 0)
+}
+
+func TestLeasingLimiter_Shared(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := store.NewMemoryWithClock(clock)
+	a := store.NewLeasingLimiterWithClock(st, "alice", 10, 0, 4, time.Minute, clock)
+	b := store.NewLeasingLimiterWithClock(st, "alice", 10, 0, 4, time.Minute, clock)
+
+	// 1, 2 and then 4 of a lease of 4 tokens.
+	for range 4 {
+		require.True(t, a.Allow())
+	}
+
+	// b gets what is left, but not what a holds.
+	require.True(t, b.AllowN(3))
+	require.False(t, b.Allow())
+
+	// Until a gives it back.
+	require.NoError(t, a.Release(t.Context()))
+	require.NoError(t, a.Release(t.Context()))
+	require.True(t, b.AllowN(3))
+	require.False(t, b.Allow())
+	require.False(t, a.Allow())
+}
+
+func TestLeasingLimiter_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := store.NewMemoryWithClock(clock)
+	lim := store.NewLeasingLimiterWithClock(st, "alice", 4, 2, 0, time.Minute, clock)
+
+	require.True(t, lim.AllowN(4))
+
+	d := lim.DecideN(3)
+	require.Equal(t, rate.Decision{
+		Limit:      4,
+		ResetAt:    clock.Now().Add(2 * time.Second),
+		RetryAfter: 1500 * time.Millisecond,
+	}, d)
+
+	// Never allowed.
+	d = lim.DecideN(5)
+	require.False(t, d.Allowed)
+	require.Zero(t, d.RetryAfter)
+
+	// The bucket refills as usual.
+	clock.Advance(1500 * time.Millisecond)
+	require.True(t, lim.AllowN(3))
+
+	clock.Advance(time.Hour)
+	require.True(t, lim.AllowN(0))
+	require.Empty(t, get(t, st, "alice"))
+}
+
+func TestLeasingLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	lim := store.NewLeasingLimiterWithClock(store.NewMemoryWithClock(clock), "alice", 10, 1, 0, 0, clock)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.True(t, at.IsZero())
+
+	// Holding tokens until the lease expires.
+	require.True(t, lim.Allow())
+	require.True(t, lim.Allow())
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.Now().Add(time.Second), at)
+}
+
+func TestLeasingLimiter_StoreError(t *testing.T) {
+	t.Parallel()
+
+	lim := store.NewLeasingLimiter(failingStore{}, "alice", 10, 1, 0, 0)
+
+	_, err := lim.DecideNContext(t.Context(), 1)
+	require.ErrorIs(t, err, errTest)
+
+	// Fails closed
+	require.False(t, lim.Allow())
+	require.Equal(t, rate.Decision{}, lim.Decide())
+
+	// Holding a lease
+	st := store.NewMemory()
+	lim = store.NewLeasingLimiter(st, "alice", 10, 1, 0, time.Hour)
+	require.True(t, lim.Allow())
+	require.True(t, lim.Allow())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, lim.Release(ctx), context.Canceled)
+
+	// Corrupt state
+	set(t, st, "bob", "[]", time.Time{})
+
+	_, err = store.NewLeasingLimiter(st, "bob", 10, 1, 0, 0).DecideNContext(t.Context(), 1)
+	require.Error(t, err)
+}
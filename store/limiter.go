@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/serroba/rate"
+)
+
+// Limiter makes the decisions of an Algorithm on state kept in a Store. For
+// each decision it creates a limiter with the factory, loads the key's state
+// into it, decides and saves the state back, in a single atomic Update. The
+// state expires once the limiter has fully recovered, so idle keys do not
+// take up space in the store.
+//
+// The limiter's configuration comes from the factory, not the store, so
+// instances sharing a store should use the same factory for the same key.
+type Limiter struct {
+	Decider
+
+	store   Store
+	key     string
+	factory AlgorithmFactory
+}
+
+// NewLimiter creates a limiter for key in s.
+func NewLimiter(s Store, key string, factory AlgorithmFactory) *Limiter {
+	l := &Limiter{store: s, key: key, factory: factory}
+	l.Decider = NewDecider(l.DecideNContext)
+
+	return l
+}
+
+// DecideNContext reports whether n requests are allowed at once, and the
+// limiter state. It passes ctx to the store and returns its error, if any.
+func (l *Limiter) DecideNContext(ctx context.Context, n uint32) (rate.Decision, error) {
+	var d rate.Decision
+
+	err := l.store.Update(ctx, l.key, func(state []byte) ([]byte, time.Time, error) {
+		alg := l.factory()
+		if state != nil {
+			if err := alg.UnmarshalJSON(state); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+
+		d = alg.DecideN(n)
+
+		at, ok := alg.RecoveredAt()
+		if ok && at.IsZero() {
+			// Nothing to remember
+			return nil, time.Time{}, nil
+		}
+
+		next, err := alg.MarshalJSON()
+		if !ok {
+			// Never recovers on its own, so never expires
+			at = time.Time{}
+		}
+
+		return next, at, err
+	})
+	if err != nil {
+		return rate.Decision{}, err
+	}
+
+	return d, nil
+}
+
+// Reset deletes the key's state from the store, so the limiter starts over
+// like a newly created one, e.g. to unblock a customer.
+func (l *Limiter) Reset(ctx context.Context) error {
+	return l.store.Update(ctx, l.key, func([]byte) ([]byte, time.Time, error) {
+		return nil, time.Time{}, nil
+	})
+}
+
+// RecoveredAt reports that the limiter holds no state of its own, so a
+// registry may evict it at any time: the state stays in the store.
+func (l *Limiter) RecoveredAt() (time.Time, bool) {
+	return time.Time{}, true
+}
@@ -0,0 +1,173 @@
+package store_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/bucket"
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/store"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+func algorithms(clock *ratetest.Clock) map[string]store.AlgorithmFactory {
+	return map[string]store.AlgorithmFactory{
+		"token":   func() store.Algorithm { return bucket.NewLimiterWithClock(5, 2, clock) },
+		"leaky":   func() store.Algorithm { return bucket.NewLeakyLimiterWithClock(5, 2, clock) },
+		"gcra":    func() store.Algorithm { return bucket.NewGCRALimiterWithClock(2, 5, clock) },
+		"atomic":  func() store.Algorithm { return bucket.NewAtomicGCRALimiterWithClock(2, 5, clock) },
+		"fixed":   func() store.Algorithm { return window.NewFixedLimiterWithClock(5, time.Second, clock) },
+		"sliding": func() store.Algorithm { return window.NewSlidingLimiterWithClock(5, time.Second, clock) },
+		"counter": func() store.Algorithm { return window.NewSlidingCounterLimiterWithClock(5, time.Second, clock) },
+	}
+}
+
+func TestLimiter_MatchesAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	for name := range algorithms(nil) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			factory := algorithms(clock)[name]
+			local := factory()
+			lim := store.NewLimiter(store.NewMemoryWithClock(clock), "alice", factory)
+
+			for i := range 200 {
+				if i%7 == 0 {
+					clock.Advance(time.Duration(i%5) * 100 * time.Millisecond)
+				}
+
+				n := uint32(i%3 + 1)
+				require.Equal(t, local.DecideN(n), lim.DecideN(n), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestLimiter_SharedStore(t *testing.T) {
+	t.Parallel()
+
+	st := store.NewMemory()
+	factory := func() store.Algorithm { return bucket.NewTokenLimiter(100, 0) }
+
+	// Two instances share the limit
+	instances := []*store.Limiter{
+		store.NewLimiter(st, "alice", factory),
+		store.NewLimiter(st, "alice", factory),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int32
+	)
+
+	for i := range 200 {
+		wg.Go(func() {
+			if instances[i%2].Allow() {
+				allowed.Add(1)
+			}
+		})
+	}
+
+	wg.Wait()
+	require.Equal(t, int32(100), allowed.Load())
+	require.True(t, store.NewLimiter(st, "bob", factory).Allow())
+}
+
+func TestLimiter_StateExpires(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	st := store.NewMemoryWithClock(clock)
+
+	lim := store.NewLimiter(st, "alice", func() store.Algorithm {
+		return bucket.NewLimiterWithClock(4, 2, clock)
+	})
+	require.True(t, lim.AllowN(3))
+	require.Equal(t, 1, st.Len())
+
+	// Full again after 1.5s
+	clock.Advance(time.Second)
+	require.Equal(t, 1, st.Len())
+	clock.Advance(500 * time.Millisecond)
+	require.Zero(t, st.Len())
+
+	// Nothing to store for a window that counted nothing
+	lim = store.NewLimiter(st, "bob", func() store.Algorithm {
+		return window.NewFixedLimiterWithClock(1, time.Minute, clock)
+	})
+	require.False(t, lim.AllowN(2))
+	require.Zero(t, st.Len())
+
+	// A bucket that never refills never expires
+	lim = store.NewLimiter(st, "carol", func() store.Algorithm {
+		return bucket.NewLimiterWithClock(1, 0, clock)
+	})
+	require.True(t, lim.Allow())
+	clock.Advance(24 * time.Hour)
+	require.False(t, lim.Allow())
+	require.Equal(t, 1, st.Len())
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Zero(t, at)
+}
+
+// failingStore fails every update.
+type failingStore struct{}
+
+func (failingStore) Update(context.Context, string, func([]byte) ([]byte, time.Time, error)) error {
+	return errTest
+}
+
+func TestLimiter_StoreError(t *testing.T) {
+	t.Parallel()
+
+	lim := store.NewLimiter(failingStore{}, "alice", func() store.Algorithm {
+		return bucket.NewTokenLimiter(1, 1)
+	})
+
+	_, err := lim.DecideNContext(t.Context(), 1)
+	require.ErrorIs(t, err, errTest)
+
+	// Fails closed
+	require.False(t, lim.Allow())
+	require.Equal(t, uint32(0), lim.Decide().Limit)
+}
+
+func TestLimiter_Reset(t *testing.T) {
+	t.Parallel()
+
+	st := store.NewMemory()
+	lim := store.NewLimiter(st, "alice", func() store.Algorithm {
+		return bucket.NewTokenLimiter(1, 0)
+	})
+
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	require.NoError(t, lim.Reset(t.Context()))
+	require.True(t, lim.Allow())
+
+	require.ErrorIs(t, store.NewLimiter(failingStore{}, "alice", nil).Reset(t.Context()), errTest)
+}
+
+func TestLimiter_StateOfOtherAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	st := store.NewMemory()
+	require.True(t, store.NewLimiter(st, "alice", func() store.Algorithm {
+		return bucket.NewTokenLimiter(2, 1)
+	}).Allow())
+
+	_, err := store.NewLimiter(st, "alice", func() store.Algorithm {
+		return bucket.NewGCRALimiter(1, 2)
+	}).DecideNContext(t.Context(), 1)
+	require.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// sweepInterval is how often Memory looks for expired keys.
+const sweepInterval = time.Minute
+
+// Memory is a Store that keeps state in process memory, which gives the same
+// behaviour as using the limiters directly. It is mainly useful for tests and
+// for running a single instance with the same setup as several.
+type Memory struct {
+	mu        sync.Mutex
+	items     map[string]item
+	nextSweep time.Time
+	clock     clock.Clock
+}
+
+type item struct {
+	state     []byte
+	expiresAt time.Time
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return NewMemoryWithClock(clock.Real{})
+}
+
+// NewMemoryWithClock creates an empty in-memory store that expires state by
+// clock.
+func NewMemoryWithClock(clock clock.Clock) *Memory {
+	return &Memory{
+		items:     make(map[string]item),
+		nextSweep: clock.Now().Add(sweepInterval),
+		clock:     clock,
+	}
+}
+
+// Update implements Store. Updates of all keys are serialized, and fn is
+// called exactly once unless ctx is already done.
+func (m *Memory) Update(
+	ctx context.Context, key string, fn func(state []byte) ([]byte, time.Time, error),
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	if !now.Before(m.nextSweep) {
+		m.sweep(now)
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	var state []byte
+	if it, ok := m.items[key]; ok && !it.expired(now) {
+		state = it.state
+	}
+
+	next, expiresAt, err := fn(state)
+	if err != nil {
+		return err
+	}
+
+	it := item{state: next, expiresAt: expiresAt}
+	if next == nil || it.expired(now) {
+		delete(m.items, key)
+
+		return nil
+	}
+
+	m.items[key] = it
+
+	return nil
+}
+
+// Len returns the number of keys with unexpired state.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(m.clock.Now())
+
+	return len(m.items)
+}
+
+// sweep deletes expired keys. The caller must hold m.mu.
+func (m *Memory) sweep(now time.Time) {
+	for key, it := range m.items {
+		if it.expired(now) {
+			delete(m.items, key)
+		}
+	}
+}
+
+func (it item) expired(now time.Time) bool {
+	return !it.expiresAt.IsZero() && !now.Before(it.expiresAt)
+}
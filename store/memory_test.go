@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test")
+
+// set stores state for key in s with the given expiry.
+func set(t *testing.T, s store.Store, key, state string, expiresAt time.Time) {
+	t.Helper()
+
+	require.NoError(t, s.Update(t.Context(), key, func([]byte) ([]byte, time.Time, error) {
+		if state == "" {
+			return nil, expiresAt, nil
+		}
+
+		return []byte(state), expiresAt, nil
+	}))
+}
+
+// get returns key's state in s.
+func get(t *testing.T, s store.Store, key string) string {
+	t.Helper()
+
+	var got string
+
+	// Abort the update to leave the state as is
+	err := s.Update(t.Context(), key, func(state []byte) ([]byte, time.Time, error) {
+		got = string(state)
+
+		return nil, time.Time{}, errTest
+	})
+	require.ErrorIs(t, err, errTest)
+
+	return got
+}
+
+func TestMemory_Update(t *testing.T) {
+	t.Parallel()
+
+	m := store.NewMemory()
+	require.Empty(t, get(t, m, "alice"))
+
+	set(t, m, "alice", "1", time.Time{})
+	require.Equal(t, "1", get(t, m, "alice"))
+	require.Empty(t, get(t, m, "bob"))
+
+	// Errors leave the state untouched
+	err := m.Update(t.Context(), "alice", func([]byte) ([]byte, time.Time, error) {
+		return []byte("2"), time.Time{}, errTest
+	})
+	require.ErrorIs(t, err, errTest)
+	require.Equal(t, "1", get(t, m, "alice"))
+
+	// No state deletes the key
+	set(t, m, "alice", "", time.Time{})
+	require.Empty(t, get(t, m, "alice"))
+	require.Zero(t, m.Len())
+}
+
+func TestMemory_Update_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	m := store.NewMemory()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	err := m.Update(ctx, "alice", func([]byte) ([]byte, time.Time, error) {
+		t.Fatal("fn called")
+
+		return nil, time.Time{}, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMemory_Expiry(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	m := store.NewMemoryWithClock(clock)
+
+	set(t, m, "alice", "1", clock.Now().Add(time.Second))
+	set(t, m, "bob", "1", clock.Now().Add(time.Hour))
+	set(t, m, "carol", "1", clock.Now())
+	require.Equal(t, 2, m.Len())
+
+	clock.Advance(time.Second)
+	require.Empty(t, get(t, m, "alice"))
+	require.Equal(t, "1", get(t, m, "bob"))
+	require.Equal(t, 1, m.Len())
+
+	// Expired keys are swept while other keys are updated
+	clock.Advance(time.Hour)
+	set(t, m, "dave", "1", time.Time{})
+	require.Empty(t, get(t, m, "bob"))
+	require.Equal(t, 1, m.Len())
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serroba/rate/clock"
+)
+
+// ErrInvalidTable is returned by NewSQL for table names that are not plain,
+// optionally schema-qualified, SQL identifiers.
+var ErrInvalidTable = errors.New("store: invalid table name")
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Dialect is the flavour of SQL spoken by the database behind a SQL store.
+type Dialect int
+
+const (
+	// SQLite is for SQLite 3.24 or later.
+	SQLite Dialect = iota + 1
+	// Postgres is for PostgreSQL 9.5 or later.
+	Postgres
+)
+
+// SQL is a Store that keeps state in a table of a SQL database, so that it
+// survives restarts of every instance. It suits long quotas, such as daily or
+// monthly fixed windows or slowly refilling token buckets, whose state would
+// be lost with process memory.
+//
+// Each Update runs in a transaction that inserts the key's row if it is
+// missing and then locks it, so concurrent updates of a key, from any
+// instance, are serialized and none is lost. Expired rows are treated as
+// missing; DeleteExpired removes them.
+//
+// With SQLite the first statement of the transaction takes the database's
+// write lock, so all updates are serialized. Set a busy timeout, e.g. with the
+// busy_timeout pragma, if the database has more than one connection.
+type SQL struct {
+	db      *sql.DB
+	queries queries
+	clock   clock.Clock
+}
+
+type queries struct {
+	create, insert, lock, update, delete, deleteExpired string
+}
+
+// NewSQL creates a store that keeps state in table of db, which speaks
+// dialect. Create the table with CreateTable.
+func NewSQL(db *sql.DB, dialect Dialect, table string) (*SQL, error) {
+	return NewSQLWithClock(db, dialect, table, clock.Real{})
+}
+
+// NewSQLWithClock creates a SQL store that expires state by clock.
+func NewSQLWithClock(db *sql.DB, dialect Dialect, table string, clock clock.Clock) (*SQL, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTable, table)
+	}
+
+	q, err := newQueries(dialect, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQL{db: db, queries: q, clock: clock}, nil
+}
+
+// newQueries builds the statements for table, which must be a valid
+// identifier. Expiries are stored as Unix nanoseconds, NULL meaning never.
+func newQueries(dialect Dialect, table string) (queries, error) {
+	var stateType, timeType, lock string
+
+	switch dialect {
+	case SQLite:
+		stateType, timeType = "BLOB", "INTEGER"
+	case Postgres:
+		stateType, timeType, lock = "BYTEA", "BIGINT", " FOR UPDATE"
+	default:
+		return queries{}, fmt.Errorf("store: unknown dialect %d", dialect)
+	}
+
+	q := queries{
+		create: "CREATE TABLE IF NOT EXISTS " + table +
+			" (key TEXT PRIMARY KEY, state " + stateType + ", expires_at " + timeType + ")",
+		insert:        "INSERT INTO " + table + " (key) VALUES (?) ON CONFLICT (key) DO NOTHING",
+		lock:          "SELECT state, expires_at FROM " + table + " WHERE key = ?" + lock,
+		update:        "UPDATE " + table + " SET state = ?, expires_at = ? WHERE key = ?",
+		delete:        "DELETE FROM " + table + " WHERE key = ?",
+		deleteExpired: "DELETE FROM " + table + " WHERE expires_at <= ?",
+	}
+
+	if dialect == Postgres {
+		for _, s := range []*string{&q.insert, &q.lock, &q.update, &q.delete, &q.deleteExpired} {
+			*s = numberParams(*s)
+		}
+	}
+
+	return q, nil
+}
+
+// numberParams replaces the ? placeholders in query with $1, $2 and so on.
+func numberParams(query string) string {
+	var b strings.Builder
+
+	n := 0
+
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+
+			continue
+		}
+
+		n++
+
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// CreateTable creates the store's table if it does not exist.
+func (s *SQL) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.queries.create)
+
+	return err
+}
+
+// Update implements Store. fn is called exactly once, with the key's row
+// locked, unless the database fails first.
+func (s *SQL) Update(
+	ctx context.Context, key string, fn func(state []byte) ([]byte, time.Time, error),
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.queries.insert, key); err != nil {
+		return err
+	}
+
+	var (
+		state     []byte
+		expiresAt sql.NullInt64
+	)
+
+	if err := tx.QueryRowContext(ctx, s.queries.lock, key).Scan(&state, &expiresAt); err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	if expiresAt.Valid && now.UnixNano() >= expiresAt.Int64 {
+		state = nil
+	}
+
+	next, at, err := fn(state)
+	if err != nil {
+		return err
+	}
+
+	if next == nil || (!at.IsZero() && !now.Before(at)) {
+		_, err = tx.ExecContext(ctx, s.queries.delete, key)
+	} else {
+		expiresAt = sql.NullInt64{Int64: at.UnixNano(), Valid: !at.IsZero()}
+		_, err = tx.ExecContext(ctx, s.queries.update, next, expiresAt, key)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpired deletes the rows whose state has expired and returns how many
+// it deleted. Expired rows are ignored anyway, so this only frees space; run
+// it now and then, e.g. daily.
+func (s *SQL) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.queries.deleteExpired, s.clock.Now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
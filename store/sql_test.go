@@ -0,0 +1,215 @@
+package store_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// newSQL returns a SQL store on a new in-memory SQLite database.
+func newSQL(t *testing.T, clock *ratetest.Clock) (*store.SQL, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// Every connection would get its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	s, err := store.NewSQLWithClock(db, store.SQLite, "rate_limits", clock)
+	require.NoError(t, err)
+	require.NoError(t, s.CreateTable(t.Context()))
+
+	return s, db
+}
+
+func TestSQL_Update(t *testing.T) {
+	t.Parallel()
+
+	s, db := newSQL(t, ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	require.Empty(t, get(t, s, "alice"))
+
+	set(t, s, "alice", "1", time.Time{})
+	require.Equal(t, "1", get(t, s, "alice"))
+	require.Empty(t, get(t, s, "bob"))
+
+	// Errors leave the state untouched
+	err := s.Update(t.Context(), "alice", func([]byte) ([]byte, time.Time, error) {
+		return []byte("2"), time.Time{}, errTest
+	})
+	require.ErrorIs(t, err, errTest)
+	require.Equal(t, "1", get(t, s, "alice"))
+
+	// No state deletes the key
+	set(t, s, "alice", "", time.Time{})
+	require.Empty(t, get(t, s, "alice"))
+
+	var rows int
+	require.NoError(t, db.QueryRowContext(t.Context(), "SELECT COUNT(*) FROM rate_limits").Scan(&rows))
+	require.Zero(t, rows)
+}
+
+func TestSQL_Expiry(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s, _ := newSQL(t, clock)
+
+	set(t, s, "alice", "1", clock.Now().Add(time.Second))
+	set(t, s, "bob", "1", clock.Now().Add(time.Hour))
+	set(t, s, "carol", "1", clock.Now())
+	set(t, s, "dave", "1", time.Time{})
+
+	clock.Advance(time.Second)
+	require.Empty(t, get(t, s, "alice"))
+	require.Equal(t, "1", get(t, s, "bob"))
+
+	clock.Advance(time.Hour)
+
+	n, err := s.DeleteExpired(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+	require.Empty(t, get(t, s, "bob"))
+	require.Equal(t, "1", get(t, s, "dave"))
+}
+
+func TestSQL_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	// A file, so that several connections share the database.
+	path := filepath.Join(t.TempDir(), "rate.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	s, err := store.NewSQL(db, store.SQLite, "rate_limits")
+	require.NoError(t, err)
+	require.NoError(t, s.CreateTable(t.Context()))
+
+	var wg sync.WaitGroup
+
+	// Concurrent increments of the same key are not lost.
+	for range 20 {
+		wg.Go(func() {
+			err := s.Update(t.Context(), "alice", func(state []byte) ([]byte, time.Time, error) {
+				return append(state, 'x'), time.Time{}, nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+
+	wg.Wait()
+	require.Len(t, get(t, s, "alice"), 20)
+}
+
+func TestSQL_Limiter(t *testing.T) {
+	t.Parallel()
+
+	for name := range algorithms(nil) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+			s, _ := newSQL(t, clock)
+			factory := algorithms(clock)[name]
+			local := factory()
+			lim := store.NewLimiter(s, "alice", factory)
+
+			for i := range 50 {
+				if i%7 == 0 {
+					clock.Advance(time.Duration(i%5) * 100 * time.Millisecond)
+				}
+
+				n := uint32(i%3 + 1)
+				require.Equal(t, local.DecideN(n), lim.DecideN(n), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestSQL_Errors(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s, db := newSQL(t, clock)
+
+	// Invalid configuration
+	_, err := store.NewSQL(db, store.SQLite, "rate limits; DROP TABLE users")
+	require.ErrorIs(t, err, store.ErrInvalidTable)
+
+	_, err = store.NewSQL(db, store.Dialect(0), "rate_limits")
+	require.Error(t, err)
+
+	// A missing table
+	other, err := store.NewSQL(db, store.Postgres, "public.missing")
+	require.NoError(t, err)
+
+	err = other.Update(t.Context(), "alice", func([]byte) ([]byte, time.Time, error) {
+		t.Fatal("fn called")
+
+		return nil, time.Time{}, nil
+	})
+	require.Error(t, err)
+
+	_, err = other.DeleteExpired(t.Context())
+	require.Error(t, err)
+
+	// A closed database
+	require.NoError(t, db.Close())
+	update := func() error {
+		return s.Update(t.Context(), "alice", func([]byte) ([]byte, time.Time, error) {
+			return []byte("1"), time.Time{}, nil
+		})
+	}
+	require.Error(t, update())
+}
+
+func TestSQL_Statements(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		dialect store.Dialect
+		want    map[string]string
+	}{
+		{
+			dialect: store.SQLite,
+			want: map[string]string{
+				"create":        "CREATE TABLE IF NOT EXISTS t (key TEXT PRIMARY KEY, state BLOB, expires_at INTEGER)",
+				"insert":        "INSERT INTO t (key) VALUES (?) ON CONFLICT (key) DO NOTHING",
+				"lock":          "SELECT state, expires_at FROM t WHERE key = ?",
+				"update":        "UPDATE t SET state = ?, expires_at = ? WHERE key = ?",
+				"delete":        "DELETE FROM t WHERE key = ?",
+				"deleteExpired": "DELETE FROM t WHERE expires_at <= ?",
+			},
+		},
+		{
+			dialect: store.Postgres,
+			want: map[string]string{
+				"create":        "CREATE TABLE IF NOT EXISTS t (key TEXT PRIMARY KEY, state BYTEA, expires_at BIGINT)",
+				"insert":        "INSERT INTO t (key) VALUES ($1) ON CONFLICT (key) DO NOTHING",
+				"lock":          "SELECT state, expires_at FROM t WHERE key = $1 FOR UPDATE",
+				"update":        "UPDATE t SET state = $1, expires_at = $2 WHERE key = $3",
+				"delete":        "DELETE FROM t WHERE key = $1",
+				"deleteExpired": "DELETE FROM t WHERE expires_at <= $1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := store.Statements(tt.dialect, "t")
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got, tt.dialect)
+	}
+
+	_, err := store.Statements(store.Postgres+1, "t")
+	require.Error(t, err)
+}
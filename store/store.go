@@ -0,0 +1,38 @@
+// Package store runs the limiters of this module against state kept in a
+// Store instead of process memory, so that several instances of a service can
+// share the same limits.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/serroba/rate"
+)
+
+// Store keeps limiter state per key.
+type Store interface {
+	// Update atomically replaces key's state with the one returned by fn.
+	// fn is passed the current state, or nil if there is none or it has
+	// expired, and must not keep or modify it. It returns the new state and
+	// when that expires, zero meaning never; a nil state deletes the key. If
+	// fn returns an error the state is left untouched and Update returns it.
+	//
+	// Stores that detect conflicting updates optimistically may call fn more
+	// than once, so it must not have side effects beyond the last call.
+	Update(ctx context.Context, key string, fn func(state []byte) (next []byte, expiresAt time.Time, err error)) error
+}
+
+// Algorithm is a limiter whose state can be loaded from and saved to a Store.
+// All limiters in the bucket and window packages implement it.
+type Algorithm interface {
+	DecideN(n uint32) rate.Decision
+	RecoveredAt() (t time.Time, ok bool)
+	json.Marshaler
+	json.Unmarshaler
+}
+
+// AlgorithmFactory creates a new limiter with the configuration to run
+// against stored state.
+type AlgorithmFactory func() Algorithm
@@ -0,0 +1,261 @@
+package window
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
+)
+
+// SlidingCounterLimiter approximates a sliding window using only two counters:
+// the counts of the current and the previous fixed window. The previous count
+// is weighted by how much of the previous window still overlaps the sliding
+// window, assuming its requests were evenly spread. This needs constant memory
+// per limiter, unlike SlidingLimiter which stores every request.
+type SlidingCounterLimiter struct {
+	mu sync.Mutex
+
+	limit, prev, curr uint32
+	window            time.Duration
+	start             time.Time // Start of the current fixed window
+	clock             clock.Clock
+}
+
+// NewSlidingCounterLimiter creates a new sliding window counter limiter.
+// Limit is the maximum requests per window. Window is the sliding window size.
+func NewSlidingCounterLimiter(limit uint32, window time.Duration) *SlidingCounterLimiter {
+	return NewSlidingCounterLimiterWithClock(limit, window, clock.Real{})
+}
+
+// NewSlidingCounterLimiterWithClock creates a new sliding window counter
+// limiter with a custom clock. Use this constructor for testing with a mock clock.
+func NewSlidingCounterLimiterWithClock(
+	limit uint32, window time.Duration, clock clock.Clock,
+) *SlidingCounterLimiter {
+	if window == 0 {
+		window = 1 * time.Second
+	}
+
+	return &SlidingCounterLimiter{
+		limit:  limit,
+		window: window,
+		clock:  clock,
+		start:  Start(clock.Now(), window),
+	}
+}
+
+// Allow reports whether a request is allowed within the sliding window.
+// Returns true if under the limit, false otherwise.
+func (l *SlidingCounterLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests fit into the sliding window at once.
+// It counts all n requests and returns true if they fit under the limit,
+// otherwise it counts nothing and returns false.
+func (l *SlidingCounterLimiter) AllowN(n uint32) bool {
+	return l.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the window state.
+func (l *SlidingCounterLimiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the window state. Remaining is what
+// is left of the limit by the current estimate and ResetAt is when the
+// estimate drops to zero.
+func (l *SlidingCounterLimiter) DecideN(n uint32) rate.Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay, err := l.take(n)
+	now := l.clock.Now()
+
+	d := rate.Decision{
+		Allowed: err == nil && delay == 0,
+		Limit:   l.limit,
+		ResetAt: now,
+	}
+
+	if est := l.estimate(now); est < float64(l.limit) {
+		d.Remaining = uint32(float64(l.limit) - est)
+	}
+
+	switch {
+	case l.curr > 0:
+		d.ResetAt = l.start.Add(2 * l.window)
+	case l.prev > 0:
+		d.ResetAt = l.start.Add(l.window)
+	}
+
+	if !d.Allowed && err == nil {
+		d.RetryAfter = delay
+	}
+
+	return d
+}
+
+// RecoveredAt returns when both counters will have rolled out of the sliding
+// window. A sliding window always recovers, so ok is always true.
+func (l *SlidingCounterLimiter) RecoveredAt() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.curr > 0:
+		return l.start.Add(2 * l.window), true
+	case l.prev > 0:
+		return l.start.Add(l.window), true
+	default:
+		return time.Time{}, true
+	}
+}
+
+// MarshalJSON encodes the current window's start and both counters.
+func (l *SlidingCounterLimiter) MarshalJSON() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return json.Marshal(counterState{Start: l.start, Prev: l.prev, Curr: l.curr})
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the limit and
+// window. As with SetWindow, the counters are re-aligned to the window
+// containing the restored start, or now if that is earlier.
+func (l *SlidingCounterLimiter) UnmarshalJSON(data []byte) error {
+	var s counterState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.start = Start(state.NotAfter(s.Start, l.clock.Now()), l.window)
+	l.prev, l.curr = s.Prev, s.Curr
+
+	return nil
+}
+
+// Wait blocks until a request fits into the sliding window or ctx is done.
+func (l *SlidingCounterLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests fit into the sliding window and counts them.
+// It sleeps until the weighted previous window has decayed enough. It returns
+// ErrExceedsLimit if n is larger than the limit, ErrWouldExceedDeadline if
+// that happens after the ctx deadline, or the ctx error if ctx is done while
+// waiting.
+func (l *SlidingCounterLimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, l.clock, func() (time.Duration, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// SetLimit changes the maximum requests per window. Requests already counted
+// still count against the new limit.
+func (l *SlidingCounterLimiter) SetLimit(limit uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+// SetWindow changes the window duration. Both counters are carried over, with
+// the current window re-aligned to the new duration, so the change never hands
+// out a fresh quota.
+func (l *SlidingCounterLimiter) SetWindow(window time.Duration) {
+	if window == 0 {
+		window = 1 * time.Second
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.roll(now)
+	l.window = window
+	l.start = Start(now, window)
+}
+
+// RefundN stops counting n requests counted by AllowN, DecideN or WaitN, e.g.
+// when the request was denied by another limiter after all. They are taken
+// from the current window's count first and from the previous one's if the
+// window has rolled since.
+func (l *SlidingCounterLimiter) RefundN(n uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.roll(l.clock.Now())
+
+	k := min(n, l.curr)
+	l.curr -= k
+	l.prev -= min(n-k, l.prev)
+}
+
+// take counts n requests if they fit and returns a zero delay. Otherwise it
+// counts nothing and returns the time until the estimate leaves room for them,
+// assuming no other requests arrive. The caller must hold l.mu.
+func (l *SlidingCounterLimiter) take(n uint32) (time.Duration, error) {
+	now := l.clock.Now()
+	l.roll(now)
+
+	if l.estimate(now)+float64(n) <= float64(l.limit) {
+		l.curr += n
+
+		return 0, nil
+	}
+
+	if n > l.limit {
+		return 0, ErrExceedsLimit
+	}
+
+	// The weight of the previous window drops to room/prev once elapsed
+	// reaches window * (1 - room/prev).
+	start, prev, room := l.start, float64(l.prev), float64(l.limit-n)
+	if float64(l.curr) > room {
+		// Not even the current count fits: wait until it becomes the
+		// previous window.
+		start, prev = start.Add(l.window), float64(l.curr)
+	} else {
+		room -= float64(l.curr)
+	}
+
+	elapsed := time.Duration(math.Ceil(float64(l.window) * (1 - room/prev)))
+
+	return max(time.Nanosecond, start.Add(elapsed).Sub(now)), nil
+}
+
+// estimate returns the weighted request count of the sliding window ending
+// at now. The caller must hold l.mu and have rolled the windows up to now.
+func (l *SlidingCounterLimiter) estimate(now time.Time) float64 {
+	weight := 1 - float64(now.Sub(l.start))/float64(l.window)
+
+	return float64(l.prev)*weight + float64(l.curr)
+}
+
+// roll moves the fixed windows forward so that the current one contains now.
+func (l *SlidingCounterLimiter) roll(now time.Time) {
+	ws := Start(now, l.window)
+
+	switch {
+	case !ws.After(l.start):
+		return
+	case ws.Equal(l.start.Add(l.window)):
+		l.prev, l.curr = l.curr, 0
+	default:
+		l.prev, l.curr = 0, 0
+	}
+
+	l.start = ws
+}
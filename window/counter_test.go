@@ -0,0 +1,236 @@
+package window_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSlidingCounterLimiter_DefaultWindow(t *testing.T) {
+	t.Parallel()
+
+	// Should not panic with zero window
+	lim := window.NewSlidingCounterLimiter(10, 0)
+	require.NotNil(t, lim)
+	require.True(t, lim.Allow())
+}
+
+func TestSlidingCounterLimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		limit            uint32
+		previousAttempts int
+		want             bool
+	}{
+		{name: "zero limit rejects immediately", limit: 0, want: false},
+		{name: "allows first request", limit: 1, want: true},
+		{name: "rejects after limit reached", limit: 1, previousAttempts: 1, want: false},
+		{name: "allows up to limit", limit: 5, previousAttempts: 4, want: true},
+		{name: "rejects at limit", limit: 5, previousAttempts: 5, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lim := window.NewSlidingCounterLimiter(tt.limit, time.Hour)
+
+			for range tt.previousAttempts {
+				lim.Allow()
+			}
+
+			require.Equal(t, tt.want, lim.Allow())
+		})
+	}
+}
+
+func TestSlidingCounterLimiter_Allow_WeightsPreviousWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+
+	require.True(t, lim.AllowN(10))
+	require.False(t, lim.Allow())
+
+	// At the start of the next window the previous one still fully overlaps
+	clock.advance(time.Minute)
+	require.False(t, lim.Allow())
+
+	// 6s in, the previous window weighs 0.9: 9 + 1 = 10
+	clock.advance(6 * time.Second)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	// Half way, the previous window weighs 0.5: 5 + 1 + 4 = 10
+	clock.advance(24 * time.Second)
+	require.True(t, lim.AllowN(4))
+	require.False(t, lim.Allow())
+
+	// Two windows later nothing is left
+	clock.advance(2 * time.Minute)
+	require.True(t, lim.AllowN(10))
+}
+
+func TestSlidingCounterLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+
+	d := lim.DecideN(0)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(10), d.Remaining)
+	require.Equal(t, clock.now, d.ResetAt)
+
+	d = lim.DecideN(10)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(10), d.Limit)
+	require.Zero(t, d.Remaining)
+	require.Equal(t, clock.now.Add(2*time.Minute), d.ResetAt)
+
+	// The current window is full - it has to become the previous one and
+	// decay to 9: 60s + 6s
+	d = lim.Decide()
+	require.False(t, d.Allowed)
+	require.Equal(t, 66*time.Second, d.RetryAfter)
+
+	// The previous window decays from 10 to 9 after 6s
+	clock.advance(time.Minute)
+
+	d = lim.Decide()
+	require.False(t, d.Allowed)
+	require.Equal(t, 6*time.Second, d.RetryAfter)
+	require.Equal(t, clock.now.Add(time.Minute), d.ResetAt)
+
+	clock.advance(30 * time.Second)
+	require.Equal(t, uint32(5), lim.DecideN(0).Remaining)
+
+	require.Zero(t, lim.DecideN(11).RetryAfter)
+}
+
+func TestSlidingCounterLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	lim := window.NewSlidingCounterLimiterWithClock(2, time.Minute, clock)
+
+	require.NoError(t, lim.WaitN(t.Context(), 2))
+	require.Zero(t, clock.Timers())
+
+	// Next window at 12:01:00, then the previous window must weigh 1: 30s more
+	done := ratetest.WaitAsync(func() error { return lim.Wait(t.Context()) })
+	ratetest.RequireWokenAfter(t, clock, done, 90*time.Second)
+	require.False(t, lim.Allow())
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 3), window.ErrExceedsLimit)
+}
+
+func TestSlidingCounterLimiter_SetLimit(t *testing.T) {
+	t.Parallel()
+
+	lim := window.NewSlidingCounterLimiter(5, time.Hour)
+	require.True(t, lim.AllowN(3))
+
+	lim.SetLimit(2)
+	require.False(t, lim.Allow())
+
+	lim.SetLimit(4)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+}
+
+func TestSlidingCounterLimiter_SetWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(3, time.Minute, clock)
+	require.True(t, lim.AllowN(2))
+
+	// The count carries over into the hour window containing now
+	lim.SetWindow(time.Hour)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	lim.SetWindow(0)
+	clock.advance(2 * time.Second)
+	require.True(t, lim.AllowN(3))
+}
+
+func TestSlidingCounterLimiter_Allow_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	lim := window.NewSlidingCounterLimiter(100, time.Hour)
+
+	var (
+		allowed atomic.Int64
+		wg      sync.WaitGroup
+	)
+
+	for range 200 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if lim.Allow() {
+				allowed.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.Equal(t, int64(100), allowed.Load())
+}
+
+func TestSlidingCounterLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Zero(t, at)
+
+	// The current window weighs in until the end of the next one
+	require.True(t, lim.Allow())
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now.Add(2*time.Minute), at)
+
+	// Once it is the previous window, until the end of the current one
+	clock.advance(time.Minute)
+	require.True(t, lim.AllowN(0))
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now.Add(time.Minute), at)
+}
+
+func TestSlidingCounterLimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+
+	require.True(t, lim.AllowN(6))
+	lim.RefundN(2)
+	require.Equal(t, uint32(6), lim.DecideN(0).Remaining)
+
+	// After the window rolls the rest is refunded from the previous count
+	require.True(t, lim.AllowN(6))
+	clock.advance(90 * time.Second)
+	require.True(t, lim.AllowN(1))
+	lim.RefundN(3)
+	require.Equal(t, uint32(6), lim.DecideN(0).Remaining)
+}
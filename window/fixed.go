@@ -1,19 +1,15 @@
 package window
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
-)
-
-type clock interface {
-	Now() time.Time
-}
 
-type realClock struct{}
-
-func (c realClock) Now() time.Time {
-	return time.Now()
-}
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
+)
 
 type FixedLimiter struct {
 	mu sync.Mutex
@@ -21,18 +17,18 @@ type FixedLimiter struct {
 	limit, count uint32
 	window       time.Duration
 	start        time.Time
-	clock        clock
+	clock        clock.Clock
 }
 
 // NewFixedLimiter creates a new fixed window rate limiter.
 // Limit is the maximum requests per window. Window is the duration of each window.
 func NewFixedLimiter(limit uint32, window time.Duration) *FixedLimiter {
-	return NewFixedLimiterWithClock(limit, window, realClock{})
+	return NewFixedLimiterWithClock(limit, window, clock.Real{})
 }
 
 // NewFixedLimiterWithClock creates a new fixed window limiter with a custom clock.
 // Use this constructor for testing with a mock clock.
-func NewFixedLimiterWithClock(limit uint32, window time.Duration, clock clock) *FixedLimiter {
+func NewFixedLimiterWithClock(limit uint32, window time.Duration, clock clock.Clock) *FixedLimiter {
 	if window == 0*time.Second {
 		window = 1 * time.Second
 	}
@@ -41,12 +37,43 @@ func NewFixedLimiterWithClock(limit uint32, window time.Duration, clock clock) *
 		limit:  limit,
 		window: window,
 		clock:  clock,
-		start:  windowStart(clock.Now(), window),
+		start:  Start(clock.Now(), window),
 	}
 }
 
-func windowStart(now time.Time, window time.Duration) time.Time {
-	ns := now.UnixNano()
+// SetLimit changes the maximum requests per window. Requests already counted
+// in the current window still count against the new limit.
+func (l *FixedLimiter) SetLimit(limit uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+// SetWindow changes the window duration. The count of the current window is
+// carried over into the new window that contains the current time, so the
+// change never hands out a fresh quota.
+func (l *FixedLimiter) SetWindow(window time.Duration) {
+	if window == 0 {
+		window = 1 * time.Second
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if !Start(now, l.window).Equal(l.start) {
+		l.count = 0
+	}
+
+	l.window = window
+	l.start = Start(now, window)
+}
+
+// Start returns the start of the window of length window that contains t.
+// Windows are aligned to the Unix epoch, so that every host agrees on them.
+func Start(t time.Time, window time.Duration) time.Time {
+	ns := t.UnixNano()
 	w := window.Nanoseconds()
 
 	return time.Unix(0, (ns/w)*w).UTC()
@@ -55,22 +82,142 @@ func windowStart(now time.Time, window time.Duration) time.Time {
 // Allow reports whether a request is allowed within the current window.
 // Returns true if under the limit, false otherwise.
 func (l *FixedLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests fit into the current window at once.
+// It counts all n requests and returns true if they fit under the limit,
+// otherwise it counts nothing and returns false.
+func (l *FixedLimiter) AllowN(n uint32) bool {
+	return l.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the window state.
+func (l *FixedLimiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the window state. Remaining is what
+// is left of the limit in the current window and ResetAt is when the next
+// window starts.
+func (l *FixedLimiter) DecideN(n uint32) rate.Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay, err := l.take(n)
+
+	d := rate.Decision{
+		Allowed: err == nil && delay == 0,
+		Limit:   l.limit,
+		ResetAt: l.start.Add(l.window),
+	}
+
+	if l.count < l.limit {
+		d.Remaining = l.limit - l.count
+	}
+
+	if !d.Allowed && err == nil {
+		d.RetryAfter = delay
+	}
+
+	return d
+}
+
+// RecoveredAt returns when the requests counted so far stop counting, i.e.
+// when the window they were counted in ends. A fixed window always recovers,
+// so ok is always true.
+func (l *FixedLimiter) RecoveredAt() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count == 0 {
+		return time.Time{}, true
+	}
+
+	return l.start.Add(l.window), true
+}
+
+// MarshalJSON encodes the current window's start and count.
+func (l *FixedLimiter) MarshalJSON() ([]byte, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	return json.Marshal(fixedState{Start: l.start, Count: l.count})
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the limit and
+// window. The count is carried into the window that contains the restored
+// start, as SetWindow does, so a change of window does not hand out a fresh
+// quota; it is dropped once that window has passed. A start later than now is
+// taken to be now.
+func (l *FixedLimiter) UnmarshalJSON(data []byte) error {
+	var s fixedState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.start = Start(state.NotAfter(s.Start, l.clock.Now()), l.window)
+	l.count = s.Count
+
+	return nil
+}
+
+// Wait blocks until a request fits into a window or ctx is done.
+func (l *FixedLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests fit into a window and counts them. When the
+// current window is full it sleeps until the next window starts. It returns
+// ErrExceedsLimit if n is larger than the limit, ErrWouldExceedDeadline if the
+// next window starts after the ctx deadline, or the ctx error if ctx is done
+// while waiting.
+func (l *FixedLimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, l.clock, func() (time.Duration, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// RefundN stops counting n requests counted by AllowN, DecideN or WaitN, e.g.
+// when the request was denied by another limiter after all. Requests counted
+// in a window that has ended no longer count anyway, so they are not
+// refunded.
+func (l *FixedLimiter) RefundN(n uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if Start(l.clock.Now(), l.window).Equal(l.start) {
+		l.count -= min(l.count, n)
+	}
+}
+
+// take counts n requests if they fit into the current window and returns a
+// zero delay. Otherwise it counts nothing and returns the time until the
+// next window starts. The caller must hold l.mu.
+func (l *FixedLimiter) take(n uint32) (time.Duration, error) {
 	now := l.clock.Now()
-	ws := windowStart(now, l.window)
+	ws := Start(now, l.window)
 
 	if !ws.Equal(l.start) {
 		l.start = ws
 		l.count = 0
 	}
 
-	if l.count+1 <= l.limit {
-		l.count++
+	if uint64(l.count)+uint64(n) <= uint64(l.limit) {
+		l.count += n
+
+		return 0, nil
+	}
 
-		return true
+	if n > l.limit {
+		return 0, ErrExceedsLimit
 	}
 
-	return false
+	return ws.Add(l.window).Sub(now), nil
 }
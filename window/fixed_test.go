@@ -22,6 +22,16 @@ func (c *testClock) advance(d time.Duration) {
 	c.now = c.now.Add(d)
 }
 
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	// Aligned to the Unix epoch, whatever the location
+	at := time.Date(2024, 1, 1, 12, 0, 10, 0, time.FixedZone("UTC+1", 3600))
+	require.Equal(t, time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC), window.Start(at, time.Minute))
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), window.Start(at, 24*time.Hour))
+	require.Equal(t, time.Date(2024, 1, 1, 11, 0, 10, 0, time.UTC), window.Start(at, time.Second))
+}
+
 func TestNewFixedLimiter_DefaultWindow(t *testing.T) {
 	t.Parallel()
 
@@ -180,3 +190,127 @@ func TestFixedLimiter_Allow_SameWindowNoReset(t *testing.T) {
 	// Should still be rejected
 	require.False(t, lim.Allow())
 }
+
+func TestFixedLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(10, time.Minute, clock)
+
+	require.True(t, lim.AllowN(6))
+	require.True(t, lim.AllowN(0))
+
+	// Only 4 left - a request for 5 is rejected and counts nothing
+	require.False(t, lim.AllowN(5))
+	require.True(t, lim.AllowN(4))
+	require.False(t, lim.AllowN(1))
+
+	// Next window resets the count
+	clock.advance(time.Minute)
+	require.False(t, lim.AllowN(11))
+	require.True(t, lim.AllowN(10))
+}
+
+func TestFixedLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+	reset := time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)
+
+	d := lim.DecideN(2)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, reset, d.ResetAt)
+	require.Zero(t, d.RetryAfter)
+
+	// Window full - retry when the next one starts
+	d = lim.DecideN(2)
+	require.False(t, d.Allowed)
+	require.Equal(t, uint32(1), d.Remaining)
+	require.Equal(t, 50*time.Second, d.RetryAfter)
+
+	require.True(t, lim.Allow())
+	require.Zero(t, lim.Decide().Remaining)
+	require.Zero(t, lim.DecideN(4).RetryAfter)
+}
+
+func TestFixedLimiter_SetLimit(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(5, time.Minute, clock)
+	require.True(t, lim.AllowN(3))
+
+	// Already over the new limit for this window
+	lim.SetLimit(2)
+	require.False(t, lim.Allow())
+	require.Zero(t, lim.DecideN(0).Remaining)
+
+	lim.SetLimit(4)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+}
+
+func TestFixedLimiter_SetWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+	require.True(t, lim.AllowN(2))
+
+	// The count carries over into the hour window containing now
+	lim.SetWindow(time.Hour)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+	require.Equal(t, time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC), lim.DecideN(0).ResetAt)
+
+	clock.advance(time.Hour)
+	require.True(t, lim.AllowN(3))
+
+	// A stale window is not carried over
+	clock.advance(time.Hour)
+	lim.SetWindow(0)
+	require.True(t, lim.AllowN(3))
+	require.Equal(t, clock.now.Add(time.Second), lim.DecideN(0).ResetAt)
+}
+
+func TestFixedLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+
+	// Nothing counted yet
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Zero(t, at)
+
+	require.True(t, lim.Allow())
+
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), at)
+}
+
+func TestFixedLimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+
+	require.True(t, lim.AllowN(3))
+	lim.RefundN(2)
+	require.Equal(t, uint32(2), lim.DecideN(0).Remaining)
+
+	lim.RefundN(5)
+	require.Equal(t, uint32(3), lim.DecideN(0).Remaining)
+
+	// Requests of a window that has ended no longer count anyway
+	require.True(t, lim.AllowN(3))
+	clock.advance(time.Minute)
+	lim.RefundN(3)
+	require.True(t, lim.AllowN(3))
+	require.False(t, lim.Allow())
+}
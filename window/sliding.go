@@ -1,8 +1,14 @@
 package window
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/serroba/rate"
+	"github.com/serroba/rate/clock"
+	"github.com/serroba/rate/internal/state"
 )
 
 // SlidingLimiter implements a sliding window rate limiter. It tracks individual
@@ -12,20 +18,28 @@ type SlidingLimiter struct {
 	mu     sync.Mutex
 	window time.Duration
 	limit  uint32
-	q      []time.Time
+	q      []entry
 	head   int
-	clock  clock
+	count  uint64 // Sum of weights in q[head:]
+	clock  clock.Clock
+}
+
+// entry is a single allowed call in the sliding log. A call made through
+// AllowN is stored once with its weight instead of n separate timestamps.
+type entry struct {
+	at time.Time
+	n  uint32
 }
 
 // NewSlidingLimiter creates a new sliding window rate limiter.
 // Limit is the maximum requests per window. Duration is the sliding window size.
 func NewSlidingLimiter(limit uint32, duration time.Duration) *SlidingLimiter {
-	return NewSlidingLimiterWithClock(limit, duration, realClock{})
+	return NewSlidingLimiterWithClock(limit, duration, clock.Real{})
 }
 
 // NewSlidingLimiterWithClock creates a new sliding window limiter with a custom clock.
 // Use this constructor for testing with a mock clock.
-func NewSlidingLimiterWithClock(limit uint32, duration time.Duration, clock clock) *SlidingLimiter {
+func NewSlidingLimiterWithClock(limit uint32, duration time.Duration, clock clock.Clock) *SlidingLimiter {
 	if duration == 0 {
 		duration = 1 * time.Second
 	}
@@ -33,7 +47,7 @@ func NewSlidingLimiterWithClock(limit uint32, duration time.Duration, clock cloc
 	return &SlidingLimiter{
 		window: duration,
 		limit:  limit,
-		q:      make([]time.Time, 0),
+		q:      make([]entry, 0),
 		clock:  clock,
 	}
 }
@@ -41,26 +55,204 @@ func NewSlidingLimiterWithClock(limit uint32, duration time.Duration, clock cloc
 // Allow reports whether a request is allowed within the sliding window.
 // Returns true if under the limit, false otherwise.
 func (l *SlidingLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+// AllowN reports whether n requests fit into the sliding window at once.
+// On success a single entry of weight n is recorded; on failure nothing is.
+func (l *SlidingLimiter) AllowN(n uint32) bool {
+	return l.DecideN(n).Allowed
+}
+
+// Decide is like Allow but also reports the window state.
+func (l *SlidingLimiter) Decide() rate.Decision {
+	return l.DecideN(1)
+}
+
+// DecideN is like AllowN but also reports the window state. Remaining is what
+// is left of the limit in the sliding window and ResetAt is when the newest
+// entry expires.
+func (l *SlidingLimiter) DecideN(n uint32) rate.Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay, err := l.take(n)
+
+	d := rate.Decision{
+		Allowed: err == nil && delay == 0,
+		Limit:   l.limit,
+		ResetAt: l.clock.Now(),
+	}
+
+	if l.count < uint64(l.limit) {
+		d.Remaining = l.limit - uint32(l.count)
+	}
+
+	if len(l.q) > l.head {
+		d.ResetAt = l.q[len(l.q)-1].at.Add(l.window + time.Nanosecond)
+	}
+
+	if !d.Allowed && err == nil {
+		d.RetryAfter = delay
+	}
+
+	return d
+}
+
+// RecoveredAt returns when the newest entry in the log expires. A sliding
+// window always recovers, so ok is always true.
+func (l *SlidingLimiter) RecoveredAt() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.q) == l.head {
+		return time.Time{}, true
+	}
+
+	return l.q[len(l.q)-1].at.Add(l.window + time.Nanosecond), true
+}
+
+// MarshalJSON encodes the entries of the log.
+func (l *SlidingLimiter) MarshalJSON() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := slidingState{Entries: make([]entryState, 0, len(l.q)-l.head)}
+	for _, e := range l.q[l.head:] {
+		s.Entries = append(s.Entries, entryState{At: e.at, N: e.n})
+	}
+
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON restores state encoded by MarshalJSON, keeping the limit and
+// window. Entries must be in chronological order. Entries later than now,
+// saved by a host whose clock ran ahead, are taken to be from now.
+func (l *SlidingLimiter) UnmarshalJSON(data []byte) error {
+	var s slidingState
+	if err := state.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	now := l.clock.Now()
-	cutoff := now.Add(-l.window)
 
-	for l.head < len(l.q) && l.q[l.head].Before(cutoff) {
-		l.head++
+	l.q, l.head, l.count = make([]entry, 0, len(s.Entries)), 0, 0
+	for _, e := range s.Entries {
+		l.q = append(l.q, entry{at: state.NotAfter(e.At, now), n: e.N})
+		l.count += uint64(e.N)
 	}
 
-	if l.head > 0 && l.head*2 >= len(l.q) {
-		l.q = append([]time.Time(nil), l.q[l.head:]...)
-		l.head = 0
+	return nil
+}
+
+// Wait blocks until a request fits into the sliding window or ctx is done.
+func (l *SlidingLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n requests fit into the sliding window and records them.
+// It sleeps until enough of the oldest entries have expired. It returns
+// ErrExceedsLimit if n is larger than the limit, ErrWouldExceedDeadline if
+// those entries expire after the ctx deadline, or the ctx error if ctx is
+// done while waiting.
+func (l *SlidingLimiter) WaitN(ctx context.Context, n uint32) error {
+	return clock.Wait(ctx, l.clock, func() (time.Duration, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.take(n)
+	}, ErrWouldExceedDeadline)
+}
+
+// take records n requests if they fit and returns a zero delay. Otherwise it
+// records nothing and returns the time until enough old entries expire.
+// The caller must hold l.mu.
+func (l *SlidingLimiter) take(n uint32) (time.Duration, error) {
+	now := l.clock.Now()
+	l.expire(now)
+
+	if l.count+uint64(n) <= uint64(l.limit) {
+		if n > 0 {
+			l.q = append(l.q, entry{at: now, n: n})
+			l.count += uint64(n)
+		}
+
+		return 0, nil
+	}
+
+	if n > l.limit {
+		return 0, ErrExceedsLimit
 	}
 
-	if len(l.q)-l.head+1 > int(l.limit) {
-		return false
+	// Walk from the oldest entry until enough weight would be freed. An entry
+	// still counts while it is exactly window old, so it frees 1ns later.
+	excess := l.count + uint64(n) - uint64(l.limit)
+
+	i := l.head
+	for freed := uint64(0); freed < excess; i++ {
+		freed += uint64(l.q[i].n)
 	}
 
-	l.q = append(l.q, now)
+	return l.q[i-1].at.Add(l.window).Sub(now) + time.Nanosecond, nil
+}
+
+// SetLimit changes the maximum requests per window. Requests already in the
+// window still count against the new limit.
+func (l *SlidingLimiter) SetLimit(limit uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+}
+
+// SetWindow changes the sliding window duration. Recorded requests are kept
+// and expire according to the new duration.
+func (l *SlidingLimiter) SetWindow(duration time.Duration) {
+	if duration == 0 {
+		duration = 1 * time.Second
+	}
 
-	return true
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.window = duration
+}
+
+// RefundN removes n requests recorded by AllowN, DecideN or WaitN from the
+// log, newest first, e.g. when the request was denied by another limiter after
+// all.
+func (l *SlidingLimiter) RefundN(n uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire(l.clock.Now())
+
+	for n > 0 && len(l.q) > l.head {
+		last := &l.q[len(l.q)-1]
+		k := min(n, last.n)
+		last.n -= k
+		l.count -= uint64(k)
+		n -= k
+
+		if last.n == 0 {
+			l.q = l.q[:len(l.q)-1]
+		}
+	}
+}
+
+func (l *SlidingLimiter) expire(now time.Time) {
+	cutoff := now.Add(-l.window)
+
+	for l.head < len(l.q) && l.q[l.head].at.Before(cutoff) {
+		l.count -= uint64(l.q[l.head].n)
+		l.head++
+	}
+
+	if l.head > 0 && l.head*2 >= len(l.q) {
+		l.q = append([]entry(nil), l.q[l.head:]...)
+		l.head = 0
+	}
 }
@@ -194,3 +194,130 @@ func TestSlidingLimiter_Allow_SameWindowNoExpiry(t *testing.T) {
 	// Still rejected
 	require.False(t, lim.Allow())
 }
+
+func TestSlidingLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(10, time.Minute, clock)
+
+	// Weight 6 at t=0
+	require.True(t, lim.AllowN(6))
+	require.True(t, lim.AllowN(0))
+
+	// Weight 3 at t=30s
+	clock.advance(30 * time.Second)
+	require.False(t, lim.AllowN(5))
+	require.True(t, lim.AllowN(3))
+	require.False(t, lim.AllowN(2))
+
+	// t=65s - the weight 6 entry expires as a whole, weight 3 remains
+	clock.advance(35 * time.Second)
+	require.False(t, lim.AllowN(8))
+	require.True(t, lim.AllowN(7))
+	require.False(t, lim.AllowN(1))
+
+	require.False(t, lim.AllowN(11))
+}
+
+func TestSlidingLimiter_Decide(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(3, time.Minute, clock)
+
+	// Empty window is already reset
+	d := lim.DecideN(0)
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Remaining)
+	require.Equal(t, clock.now, d.ResetAt)
+
+	require.True(t, lim.AllowN(2))
+	clock.advance(30 * time.Second)
+
+	d = lim.Decide()
+	require.True(t, d.Allowed)
+	require.Equal(t, uint32(3), d.Limit)
+	require.Zero(t, d.Remaining)
+	require.Equal(t, clock.now.Add(time.Minute+time.Nanosecond), d.ResetAt)
+
+	// Full - retry once the t=0 entry expires
+	d = lim.Decide()
+	require.False(t, d.Allowed)
+	require.Equal(t, 30*time.Second+time.Nanosecond, d.RetryAfter)
+
+	require.Zero(t, lim.DecideN(4).RetryAfter)
+}
+
+func TestSlidingLimiter_SetLimit(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(5, time.Minute, clock)
+	require.True(t, lim.AllowN(3))
+
+	lim.SetLimit(2)
+	require.False(t, lim.Allow())
+
+	lim.SetLimit(4)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+}
+
+func TestSlidingLimiter_SetWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(2, time.Minute, clock)
+	require.True(t, lim.AllowN(2))
+
+	// Recorded requests now expire after the longer window
+	lim.SetWindow(time.Hour)
+	clock.advance(2 * time.Minute)
+	require.False(t, lim.Allow())
+
+	// And sooner after a shorter one
+	lim.SetWindow(0)
+	require.True(t, lim.AllowN(2))
+}
+
+func TestSlidingLimiter_RecoveredAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(3, time.Minute, clock)
+
+	at, ok := lim.RecoveredAt()
+	require.True(t, ok)
+	require.Zero(t, at)
+
+	require.True(t, lim.Allow())
+	clock.advance(30 * time.Second)
+	require.True(t, lim.Allow())
+
+	// Recovered once the newest entry expires
+	at, ok = lim.RecoveredAt()
+	require.True(t, ok)
+	require.Equal(t, clock.now.Add(time.Minute+time.Nanosecond), at)
+}
+
+func TestSlidingLimiter_RefundN(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(5, time.Minute, clock)
+
+	require.True(t, lim.AllowN(2))
+	clock.advance(30 * time.Second)
+	require.True(t, lim.AllowN(3))
+
+	// The newest requests are refunded first
+	lim.RefundN(4)
+	require.Equal(t, uint32(4), lim.DecideN(0).Remaining)
+
+	clock.advance(31 * time.Second)
+	require.Equal(t, uint32(5), lim.DecideN(0).Remaining)
+
+	lim.RefundN(1)
+	require.True(t, lim.AllowN(5))
+}
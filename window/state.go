@@ -0,0 +1,26 @@
+package window
+
+import "time"
+
+// The states the limiters marshal to JSON; see the internal/state package.
+// Windows that ended in between are expired.
+
+type fixedState struct {
+	Start time.Time `json:"start"`
+	Count uint32    `json:"count"`
+}
+
+type slidingState struct {
+	Entries []entryState `json:"entries"`
+}
+
+type entryState struct {
+	At time.Time `json:"at"`
+	N  uint32    `json:"n"`
+}
+
+type counterState struct {
+	Start time.Time `json:"start"`
+	Prev  uint32    `json:"prev"`
+	Curr  uint32    `json:"curr"`
+}
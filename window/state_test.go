@@ -0,0 +1,152 @@
+package window_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedLimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)}
+	lim := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+	require.True(t, lim.AllowN(2))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// Restored within the same window
+	clock.advance(20 * time.Second)
+
+	restored := window.NewFixedLimiterWithClock(3, time.Minute, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(2))
+	require.True(t, restored.Allow())
+
+	// Restored after the window has passed
+	clock.advance(time.Minute)
+
+	restored = window.NewFixedLimiterWithClock(3, time.Minute, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.True(t, restored.AllowN(3))
+}
+
+func TestSlidingLimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingLimiterWithClock(3, time.Minute, clock)
+	require.True(t, lim.AllowN(2))
+	clock.advance(30 * time.Second)
+	require.True(t, lim.Allow())
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// The first entry has expired by the time it is restored
+	clock.advance(31 * time.Second)
+
+	restored := window.NewSlidingLimiterWithClock(3, time.Minute, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(3))
+	require.True(t, restored.AllowN(2))
+}
+
+func TestSlidingCounterLimiter_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	lim := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+	require.True(t, lim.AllowN(10))
+
+	data, err := json.Marshal(lim)
+	require.NoError(t, err)
+
+	// Half way into the next window, the previous one weighs 5
+	clock.advance(90 * time.Second)
+
+	restored := window.NewSlidingCounterLimiterWithClock(10, time.Minute, clock)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.False(t, restored.AllowN(6))
+	require.True(t, restored.AllowN(5))
+}
+
+func TestUnmarshalJSON_FutureState(t *testing.T) {
+	t.Parallel()
+
+	ahead := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	clock := &testClock{now: ahead.now}
+
+	// Saved on a host whose clock ran 1000s ahead
+	ahead.advance(1000 * time.Second)
+
+	tests := []struct {
+		name     string
+		saved    limiter
+		restored limiter
+	}{
+		{
+			name:     "fixed",
+			saved:    window.NewFixedLimiterWithClock(3, time.Minute, ahead),
+			restored: window.NewFixedLimiterWithClock(3, time.Minute, clock),
+		},
+		{
+			name:     "sliding",
+			saved:    window.NewSlidingLimiterWithClock(3, time.Minute, ahead),
+			restored: window.NewSlidingLimiterWithClock(3, time.Minute, clock),
+		},
+		{
+			name:     "counter",
+			saved:    window.NewSlidingCounterLimiterWithClock(3, time.Minute, ahead),
+			restored: window.NewSlidingCounterLimiterWithClock(3, time.Minute, clock),
+		},
+	}
+
+	for _, tt := range tests {
+		require.True(t, tt.saved.AllowN(3), tt.name)
+
+		data, err := json.Marshal(tt.saved)
+		require.NoError(t, err, tt.name)
+		require.NoError(t, json.Unmarshal(data, tt.restored), tt.name)
+		require.False(t, tt.restored.Allow(), tt.name)
+
+		// Within two windows of now, not of the future time saved
+		at, ok := tt.restored.RecoveredAt()
+		require.True(t, ok, tt.name)
+		require.False(t, at.After(clock.now.Add(2*time.Minute)), tt.name)
+	}
+
+	clock.advance(2 * time.Minute)
+
+	for _, tt := range tests {
+		require.True(t, tt.restored.AllowN(3), tt.name)
+	}
+}
+
+// limiter is what the state tests need of the limiters.
+type limiter interface {
+	AllowN(n uint32) bool
+	Allow() bool
+	RecoveredAt() (time.Time, bool)
+	json.Marshaler
+	json.Unmarshaler
+}
+
+func TestUnmarshalJSON_WrongLimiter(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(window.NewFixedLimiter(1, time.Second))
+	require.NoError(t, err)
+
+	require.Error(t, json.Unmarshal(data, window.NewSlidingLimiter(1, time.Second)))
+
+	data, err = json.Marshal(window.NewSlidingLimiter(1, time.Second))
+	require.NoError(t, err)
+
+	require.Error(t, json.Unmarshal(data, window.NewFixedLimiter(1, time.Second)))
+	require.Error(t, json.Unmarshal(data, window.NewSlidingCounterLimiter(1, time.Second)))
+}
@@ -0,0 +1,13 @@
+package window
+
+import "errors"
+
+var (
+	// ErrExceedsLimit is returned by WaitN when n is larger than the window
+	// limit, so the request could never be satisfied no matter how long it waits.
+	ErrExceedsLimit = errors.New("window: n exceeds limiter limit")
+
+	// ErrWouldExceedDeadline is returned by WaitN when the time until the next
+	// permit is later than the context deadline.
+	ErrWouldExceedDeadline = errors.New("window: wait would exceed context deadline")
+)
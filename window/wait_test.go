@@ -0,0 +1,89 @@
+package window_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serroba/rate/ratetest"
+	"github.com/serroba/rate/window"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC))
+	lim := window.NewFixedLimiterWithClock(2, time.Minute, clock)
+
+	require.NoError(t, lim.WaitN(t.Context(), 2))
+	require.Zero(t, clock.Timers())
+
+	// Window full - sleeps until 12:01:00
+	done := ratetest.WaitAsync(func() error { return lim.Wait(t.Context()) })
+	ratetest.RequireWokenAfter(t, clock, done, 50*time.Second)
+	require.True(t, lim.Allow())
+	require.False(t, lim.Allow())
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 3), window.ErrExceedsLimit)
+}
+
+func TestFixedLimiter_Wait_Deadline(t *testing.T) {
+	t.Parallel()
+
+	lim := window.NewFixedLimiter(1, time.Hour)
+	require.True(t, lim.Allow())
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, lim.Wait(ctx), window.ErrWouldExceedDeadline)
+}
+
+func TestFixedLimiter_Wait_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	lim := window.NewFixedLimiterWithClock(1, time.Hour, clock)
+	require.True(t, lim.Allow())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := ratetest.WaitAsync(func() error { return lim.Wait(ctx) })
+
+	clock.BlockUntil(1)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestFixedLimiter_Wait_RealClock(t *testing.T) {
+	t.Parallel()
+
+	lim := window.NewFixedLimiter(1, 10*time.Millisecond)
+
+	require.NoError(t, lim.Wait(t.Context()))
+	require.NoError(t, lim.Wait(t.Context()))
+}
+
+func TestSlidingLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := ratetest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	lim := window.NewSlidingLimiterWithClock(3, time.Minute, clock)
+
+	// Weight 2 at t=0, weight 1 at t=30s
+	require.NoError(t, lim.WaitN(t.Context(), 2))
+	clock.Advance(30 * time.Second)
+	require.NoError(t, lim.Wait(t.Context()))
+	require.Zero(t, clock.Timers())
+
+	// One request only needs the t=0 entry to expire
+	done := ratetest.WaitAsync(func() error { return lim.Wait(t.Context()) })
+	ratetest.RequireWokenAfter(t, clock, done, 30*time.Second+time.Nanosecond)
+
+	// Now at t=60s+1ns with weights 1 (t=30s) and 1 (now): three requests
+	// need both to expire
+	done = ratetest.WaitAsync(func() error { return lim.WaitN(t.Context(), 3) })
+	ratetest.RequireWokenAfter(t, clock, done, time.Minute+time.Nanosecond)
+
+	require.ErrorIs(t, lim.WaitN(t.Context(), 4), window.ErrExceedsLimit)
+}